@@ -18,9 +18,11 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"io"
+	"io/fs"
 	"log"
 	"net/url"
 	"os"
@@ -41,8 +43,23 @@ var (
 	timeout  = flag.Duration("timeout", 5*time.Second, "timeout for all operations")
 	user     = flag.String("user", "anonymous", "ftp user name")
 	password = flag.String("password", "anonymous", "ftp password")
+	workers  = flag.Int("workers", ftputil.DefaultWorkers, "directories to list in parallel")
+	skip     = flag.Bool("skip-errors", false, "skip directories which cannot be listed")
+	maxDepth = flag.Int("max-depth", 0, "directory levels to descend, 0 for no limit")
+	follow   = flag.Bool("follow", false, "follow symbolic links")
+	hash     = flag.Bool("hash", false, "ask the server for MD5 hashes to compare")
+
+	include, exclude     ftputil.Globs
+	includeRe, excludeRe ftputil.Regexps
 )
 
+func init() {
+	flag.Var(&include, "include", "only copy paths matching this glob, may be repeated")
+	flag.Var(&exclude, "exclude", "skip paths matching this glob, may be repeated")
+	flag.Var(&includeRe, "include-regexp", "only copy paths matching this regular expression, may be repeated; lists the whole tree to find them")
+	flag.Var(&excludeRe, "exclude-regexp", "skip paths matching this regular expression, may be repeated")
+}
+
 func main() {
 	ctx := context.Background()
 	flag.Parse()
@@ -78,6 +95,8 @@ func main() {
 			User:     *user,
 			Password: *password,
 			Timeout:  *timeout,
+
+			ConnectionsPerHost: *workers,
 		}
 		if server.Scheme == "ftps" {
 			config.TLSConfig = &tls.Config{
@@ -127,14 +146,61 @@ func main() {
 		objs["/"+obj.Name] = obj
 	}
 
-	files, err := ftputil.FindFiles(client, server.Path)
-	if err != nil {
-		log.Fatalln("Listing files failed:", err)
+	var raw goftp.RawConn
+	if *hash {
+		raw, err = client.OpenRawConn()
+		if err != nil {
+			log.Fatalln("Client failed:", err)
+		}
+		defer raw.Close()
 	}
 
-	for name, file := range files {
-		if obj, ok := objs[name]; ok && QuickCheck(obj, file) {
-			continue
+	walker := ftputil.Walker{
+		Client:   client,
+		Workers:  *workers,
+		Include:  append(include, includeRe...),
+		Exclude:  append(exclude, excludeRe...),
+		MaxDepth: *maxDepth,
+	}
+	if *skip {
+		walker.Errors = ftputil.ErrorSkip
+	}
+	if *follow {
+		walker.Links = ftputil.LinkFollow
+	}
+	err = walker.Walk(server.Path, func(name string, d fs.DirEntry, err error) error {
+		if errors.Is(err, ftputil.ErrLinkLoop) {
+			log.Printf("Skipped %s: %s", name, err)
+			return nil
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		file, err := d.Info()
+		if err != nil {
+			return err
+		}
+		attrs, ok := objs[name]
+		entry, isEntry := file.(*ftputil.Entry)
+		// Only worth asking when the sizes already match.
+		if ok && raw != nil && isEntry && len(attrs.MD5) != 0 && attrs.Size == file.Size() {
+			if _, ok := entry.Hashes["MD5"]; !ok {
+				// Without a hash QuickCheck falls back to mtime.
+				sum, err := ftputil.FetchHash(raw, name, "MD5")
+				if errors.Is(err, ftputil.ErrNoHash) {
+					log.Printf("No hash for %s: %s", name, err)
+				} else if err != nil {
+					log.Fatalln("Fetching hash failed:", err)
+				} else {
+					if entry.Hashes == nil {
+						entry.Hashes = make(map[string]string)
+					}
+					entry.Hashes["MD5"] = sum
+				}
+			}
+		}
+		if ok && QuickCheck(attrs, file) {
+			return nil
 		}
 
 		obj := gcs.Bucket(target.Host).Object(strings.TrimPrefix(name, "/"))
@@ -148,6 +214,16 @@ func main() {
 			log.Fatalln("Write/Close failed:", err)
 		}
 		log.Println(name)
+		return nil
+	})
+	var skipped ftputil.WalkError
+	if errors.As(err, &skipped) {
+		for _, e := range skipped {
+			log.Printf("Skipped %s: %s", e.Path, e.Err)
+		}
+		log.Fatalf("Skipped %d directories", len(skipped))
+	} else if err != nil {
+		log.Fatalln("Listing files failed:", err)
 	}
 }
 
@@ -204,7 +280,8 @@ func SetModTime(w *storage.Writer, mtime time.Time) error {
 	return nil
 }
 
-// QuickCheck compares Object and FileInfo size and mtime. Returns true on match.
+// QuickCheck compares Object and FileInfo size and mtime, or MD5 if the
+// FileInfo is an ftputil.Entry with one. Returns true on match.
 func QuickCheck(obj *storage.ObjectAttrs, fi os.FileInfo) bool {
 	if obj == nil || fi == nil {
 		return false
@@ -218,6 +295,13 @@ func QuickCheck(obj *storage.ObjectAttrs, fi os.FileInfo) bool {
 		return false
 	}
 
+	// Content hashes beat mtime, which may not survive mirroring.
+	if e, ok := fi.(*ftputil.Entry); ok && len(obj.MD5) != 0 {
+		if sum, ok := e.Hashes["MD5"]; ok {
+			return sum == hex.EncodeToString(obj.MD5)
+		}
+	}
+
 	// Use mtime if available and valid, otherwise just skip.
 	if mtime, err := ObjModTime(obj); err != nil || !mtime.Equal(fi.ModTime()) {
 		return false
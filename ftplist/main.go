@@ -17,9 +17,11 @@ package main
 
 import (
 	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"net/url"
 	"os"
@@ -36,8 +38,22 @@ var (
 	timeout  = flag.Duration("timeout", 5*time.Second, "timeout for all operations")
 	user     = flag.String("user", "anonymous", "ftp user name")
 	password = flag.String("password", "anonymous", "ftp password")
+	workers  = flag.Int("workers", ftputil.DefaultWorkers, "directories to list in parallel")
+	skip     = flag.Bool("skip-errors", false, "skip directories which cannot be listed")
+	maxDepth = flag.Int("max-depth", 0, "directory levels to descend, 0 for no limit")
+	follow   = flag.Bool("follow", false, "follow symbolic links")
+
+	include, exclude     ftputil.Globs
+	includeRe, excludeRe ftputil.Regexps
 )
 
+func init() {
+	flag.Var(&include, "include", "only list paths matching this glob, may be repeated")
+	flag.Var(&exclude, "exclude", "skip paths matching this glob, may be repeated")
+	flag.Var(&includeRe, "include-regexp", "only list paths matching this regular expression, may be repeated; lists the whole tree to find them")
+	flag.Var(&excludeRe, "exclude-regexp", "skip paths matching this regular expression, may be repeated")
+}
+
 func main() {
 	flag.Parse()
 
@@ -72,6 +88,8 @@ func main() {
 			User:     *user,
 			Password: *password,
 			Timeout:  *timeout,
+
+			ConnectionsPerHost: *workers,
 		}
 		if server.Scheme == "ftps" {
 			config.TLSConfig = &tls.Config{
@@ -91,12 +109,44 @@ func main() {
 		defer client.Close()
 	}
 
-	files, err := ftputil.FindFiles(client, server.Path)
-	if err != nil {
-		log.Fatalln("Listing files failed:", err)
+	walker := ftputil.Walker{
+		Client:   client,
+		Workers:  *workers,
+		Include:  append(include, includeRe...),
+		Exclude:  append(exclude, excludeRe...),
+		MaxDepth: *maxDepth,
 	}
-
-	for name, file := range files {
-		fmt.Println(name, file)
+	if *skip {
+		walker.Errors = ftputil.ErrorSkip
+	}
+	if *follow {
+		walker.Links = ftputil.LinkFollow
+	}
+	err := walker.Walk(server.Path, func(name string, d fs.DirEntry, err error) error {
+		if errors.Is(err, ftputil.ErrLinkLoop) {
+			log.Printf("Skipped %s: %s", name, err)
+			return nil
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if target, ok := ftputil.LinkTarget(info); ok {
+			name += " -> " + target
+		}
+		fmt.Println(name, info)
+		return nil
+	})
+	var skipped ftputil.WalkError
+	if errors.As(err, &skipped) {
+		for _, e := range skipped {
+			log.Printf("Skipped %s: %s", e.Path, e.Err)
+		}
+		log.Fatalf("Skipped %d directories", len(skipped))
+	} else if err != nil {
+		log.Fatalln("Listing files failed:", err)
 	}
 }
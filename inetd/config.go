@@ -0,0 +1,204 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/marineam/experiments/network/inetd"
+)
+
+type Config struct {
+	Services []Service `json:"services"`
+}
+
+type Service struct {
+	Name          string   `json:"name"`
+	Network       string   `json:"network"`
+	Address       string   `json:"address"`
+	Addresses     []string `json:"addresses"`
+	FDName        string   `json:"fdname"`
+	Program       string   `json:"program"`
+	Args          []string `json:"args"`
+	Mode          string   `json:"mode"`
+	Instances     int      `json:"instances"`
+	PerSource     int      `json:"per_source"`
+	Queue         bool     `json:"queue"`
+	QueueLimit    int      `json:"queue_limit"`
+	Banner        string   `json:"banner"`
+	User          string   `json:"user"`
+	Env           []string `json:"env"`
+	Dir           string   `json:"dir"`
+	TLSCert       string   `json:"tls_cert"`
+	TLSKey        string   `json:"tls_key"`
+	ProxyProtocol bool     `json:"proxy_protocol"`
+	RlimitCPU     int      `json:"rlimit_cpu"` // seconds
+	RlimitAS      uint64   `json:"rlimit_as"`
+	RlimitFiles   uint64   `json:"rlimit_files"`
+	Timeout       int      `json:"timeout"` // seconds
+	Cgroup        string   `json:"cgroup"`
+	MemoryMax     int64    `json:"memory_max"`
+	PidsMax       int64    `json:"pids_max"`
+	Rate          float64  `json:"rate"`
+	Burst         int      `json:"burst"`
+	SourceRate    float64  `json:"per_source_rate"`
+	SourceBurst   int      `json:"per_source_burst"`
+	Allow         []string `json:"allow"`
+	Deny          []string `json:"deny"`
+}
+
+var modes = map[string]inetd.Mode{
+	"":       inetd.Nowait,
+	"nowait": inetd.Nowait,
+	"accept": inetd.Accept,
+	"wait":   inetd.Wait,
+}
+
+func ReadConfig(name string) (*Config, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	seen := make(map[string]bool)
+	for _, svc := range config.Services {
+		if svc.Name == "" {
+			return nil, fmt.Errorf("%s: service missing name", name)
+		}
+		if seen[svc.Name] {
+			return nil, fmt.Errorf("%s: duplicate service %q", name, svc.Name)
+		}
+		seen[svc.Name] = true
+		if len(svc.Addrs()) == 0 && svc.FDName == "" {
+			return nil, fmt.Errorf("%s: service %q has no address", name, svc.Name)
+		}
+		if _, ok := modes[svc.Mode]; !ok {
+			return nil, fmt.Errorf("%s: service %q has invalid mode %q", name, svc.Name, svc.Mode)
+		}
+	}
+
+	return &config, nil
+}
+
+// Addrs returns the addresses the service listens on, address and
+// addresses combined.
+func (svc *Service) Addrs() []inetd.Address {
+	var addrs []inetd.Address
+	if svc.Address != "" {
+		addrs = append(addrs, inetd.Address{Network: svc.Network, Address: svc.Address})
+	}
+	for _, a := range svc.Addresses {
+		addrs = append(addrs, inetd.Address{Network: svc.Network, Address: a})
+	}
+	return addrs
+}
+
+// socketKey identifies the sockets a service listens on, services
+// with the same key can take over each other's sockets.
+func (svc *Service) socketKey() string {
+	key := svc.Network
+	for _, a := range svc.Addrs() {
+		key += " " + a.Address
+	}
+	return key
+}
+
+// Packet reports if the service uses a datagram network.
+func (svc *Service) Packet() bool {
+	return strings.HasPrefix(svc.Network, "udp") || svc.Network == "unixgram"
+}
+
+// InetdConfig translates the service into settings for package inetd.
+func (svc *Service) InetdConfig() (inetd.Config, error) {
+	config := inetd.Config{
+		Instances:     svc.Instances,
+		PerSource:     svc.PerSource,
+		Queue:         svc.Queue,
+		QueueLimit:    svc.QueueLimit,
+		Banner:        svc.Banner,
+		Mode:          modes[svc.Mode],
+		Name:          svc.Name,
+		Env:           svc.Env,
+		Dir:           svc.Dir,
+		ProxyProtocol: svc.ProxyProtocol,
+
+		RlimitCPU:    time.Duration(svc.RlimitCPU) * time.Second,
+		RlimitAS:     svc.RlimitAS,
+		RlimitNofile: svc.RlimitFiles,
+		Timeout:      time.Duration(svc.Timeout) * time.Second,
+
+		Cgroup:          svc.Cgroup,
+		CgroupMemoryMax: svc.MemoryMax,
+		CgroupPidsMax:   svc.PidsMax,
+
+		Rate:           svc.Rate,
+		Burst:          svc.Burst,
+		PerSourceRate:  svc.SourceRate,
+		PerSourceBurst: svc.SourceBurst,
+
+		Allow: svc.Allow,
+		Deny:  svc.Deny,
+	}
+
+	if svc.User != "" {
+		cred, err := lookupUser(svc.User)
+		if err != nil {
+			return config, err
+		}
+		config.SysProcAttr = &syscall.SysProcAttr{Credential: cred}
+	}
+
+	if svc.TLSCert != "" || svc.TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(svc.TLSCert, svc.TLSKey)
+		if err != nil {
+			return config, err
+		}
+		config.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.RequestClientCert,
+		}
+	}
+
+	return config, nil
+}
+
+func lookupUser(name string) (*syscall.Credential, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	return &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}, nil
+}
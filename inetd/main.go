@@ -0,0 +1,473 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Run many inetd services described by a JSON config file.
+//
+// The config file lists services, all fields but name, network, program
+// and address, addresses or fdname are optional. Host names in addresses
+// listen on every address the name resolves to. Instead of addresses a
+// service may use sockets passed by systemd with the given fdname, as
+// named by FileDescriptorName= in the socket unit:
+//
+//	{
+//	  "services": [
+//	    {
+//	      "name": "echo",
+//	      "network": "tcp",
+//	      "address": ":7777",
+//	      "addresses": ["localhost:7778"],
+//	      "program": "/bin/cat",
+//	      "args": [],
+//	      "mode": "nowait",
+//	      "instances": 10,
+//	      "per_source": 2,
+//	      "queue": false,
+//	      "queue_limit": 128,
+//	      "banner": "busy\r\n",
+//	      "user": "nobody",
+//	      "env": ["FOO=bar"],
+//	      "dir": "/",
+//	      "tls_cert": "/etc/ssl/echo.pem",
+//	      "tls_key": "/etc/ssl/echo.key",
+//	      "proxy_protocol": false,
+//	      "rlimit_cpu": 60,
+//	      "rlimit_as": 1073741824,
+//	      "rlimit_files": 256,
+//	      "timeout": 3600,
+//	      "cgroup": "/sys/fs/cgroup/inetd.slice",
+//	      "memory_max": 268435456,
+//	      "pids_max": 64,
+//	      "rate": 50,
+//	      "burst": 100,
+//	      "per_source_rate": 1,
+//	      "per_source_burst": 5,
+//	      "allow": ["10.0.0.0/8", "uid:1000"],
+//	      "deny": ["ALL"]
+//	    }
+//	  ]
+//	}
+//
+// Send SIGHUP to reload the config file. Services that changed are
+// started again with the new settings, taking over the listening
+// sockets if the addresses are the same, and existing children are left
+// running. A service which fails to start keeps its previous settings. Send SIGUSR1 to log the status
+// of each service. SIGINT or SIGTERM shut everything down.
+//
+// Send SIGUSR2 to upgrade to a new binary without dropping connections:
+// the binary is started again with the same arguments and takes over
+// the listening sockets. Once its services are running the old process
+// stops accepting connections and exits after its children do, or
+// shuts them down if they are still running after -drain. If any
+// service fails to start the new process exits and the old one keeps
+// running. Under systemd the old process tells it the new main PID with
+// sd_notify, the service unit must allow that with NotifyAccess=main or
+// all, otherwise systemd stops the new process when the old one exits.
+//
+// With -metrics connection and child counts for each service are
+// served in the Prometheus text format at /metrics.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/marineam/experiments/network/inetd"
+)
+
+var (
+	config  = flag.String("config", "/etc/inetd.json", "service config file")
+	debug   = flag.Bool("debug", false, "log every connection")
+	timeout = flag.Duration("timeout", 10*time.Second, "time to wait for children on shutdown")
+	listen  = flag.String("metrics", "", "address to serve Prometheus metrics on, such as :9100")
+	drain   = flag.Duration("drain", time.Hour, "time to wait for children after an upgrade before shutting down")
+)
+
+type running struct {
+	svc   Service
+	inetd *inetd.Inetd
+}
+
+type Supervisor struct {
+	services map[string]*running
+	// retired services no longer accept connections but may still
+	// have children which need to be shut down on exit. Each is
+	// dropped once its children exit, tracked by draining.
+	mu       sync.Mutex
+	retired  []*inetd.Inetd
+	draining sync.WaitGroup
+	metrics  *inetd.Metrics
+	// inherited sockets from systemd by name, kept open so services
+	// using them can be restarted.
+	inherited map[string][]*os.File
+	// upgraded sockets passed by a previous process by socketKey,
+	// only used when first loading the config.
+	upgraded map[string][]*os.File
+	// upgrading makes Load fail if any service fails to start, the
+	// previous process then keeps running them instead.
+	upgrading bool
+
+	metricsListener net.Listener
+	server          *http.Server
+}
+
+func newSupervisor() *Supervisor {
+	return &Supervisor{
+		services:  make(map[string]*running),
+		metrics:   inetd.NewMetrics(),
+		inherited: make(map[string][]*os.File),
+		upgraded:  make(map[string][]*os.File),
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2,
+		syscall.SIGINT, syscall.SIGTERM)
+
+	s := newSupervisor()
+
+	files, err := inetd.ActivationFiles()
+	if err != nil {
+		log.Fatalln("Socket activation failed:", err)
+	}
+	for _, f := range files {
+		s.inherited[f.Name()] = append(s.inherited[f.Name()], f)
+	}
+	ready, err := s.inheritUpgrade()
+	if err != nil {
+		log.Fatalln("Upgrade failed:", err)
+	}
+
+	s.upgrading = ready != nil
+	if err := s.Load(*config); err != nil {
+		log.Fatalln("Loading config failed:", err)
+	}
+	s.upgrading = false
+	if *listen != "" {
+		if err := s.serveMetrics(*listen); err != nil {
+			log.Fatalln("Serving metrics failed:", err)
+		}
+	}
+	s.closeUpgraded()
+	if ready != nil {
+		fmt.Fprintln(ready, "ready")
+		ready.Close()
+	}
+
+	// drained is closed once the children of an upgraded process exit.
+	var drained chan struct{}
+	for {
+		select {
+		case <-drained:
+			log.Println("All children exited, upgrade complete")
+			return
+		case sig := <-sigs:
+			if drained != nil && (sig == syscall.SIGHUP || sig == syscall.SIGUSR2) {
+				log.Println("Ignoring", sig, "after upgrade")
+				continue
+			}
+			switch sig {
+			case syscall.SIGHUP:
+				log.Println("Reloading", *config)
+				if err := s.Load(*config); err != nil {
+					log.Println("Reloading config failed:", err)
+				}
+			case syscall.SIGUSR1:
+				s.Status()
+			case syscall.SIGUSR2:
+				log.Println("Upgrading", os.Args[0])
+				if err := s.Upgrade(); err != nil {
+					log.Println("Upgrade failed:", err)
+					continue
+				}
+				drained = make(chan struct{})
+				go func() {
+					s.Drain()
+					close(drained)
+				}()
+			default:
+				log.Println("Shutting down on", sig)
+				s.Shutdown()
+				return
+			}
+		}
+	}
+}
+
+// Load starts, restarts or stops services to match the config file.
+// Services which fail to start are only logged unless upgrading.
+func (s *Supervisor) Load(name string) error {
+	c, err := ReadConfig(name)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+
+	keep := make(map[string]bool)
+	for _, svc := range c.Services {
+		keep[svc.Name] = true
+		if r, ok := s.services[svc.Name]; ok {
+			if reflect.DeepEqual(r.svc, svc) {
+				continue
+			}
+			if err := s.restart(r, svc); err != nil {
+				log.Printf("%s: start failed, keeping previous config: %s", svc.Name, err)
+				errs = append(errs, fmt.Errorf("%s: %w", svc.Name, err))
+			}
+			continue
+		}
+		if err := s.start(svc, s.upgraded[svc.socketKey()]); err != nil {
+			log.Printf("%s: start failed: %s", svc.Name, err)
+			errs = append(errs, fmt.Errorf("%s: %w", svc.Name, err))
+		}
+	}
+
+	for name := range s.services {
+		if !keep[name] {
+			s.retire(name)
+		}
+	}
+
+	if s.upgrading {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// start starts a service, listening on files if given instead of
+// binding its addresses.
+func (s *Supervisor) start(svc Service, files []*os.File) error {
+	config, err := svc.InetdConfig()
+	if err != nil {
+		return err
+	}
+	config.Observer = inetd.MultiObserver(
+		observer(svc.Name), s.metrics.Observer(svc.Name))
+
+	if svc.FDName != "" {
+		files = s.inherited[svc.FDName]
+		if len(files) == 0 {
+			return fmt.Errorf("no inherited sockets named %q", svc.FDName)
+		}
+	}
+
+	var i *inetd.Inetd
+	if len(files) != 0 {
+		i, err = inetd.ListenFiles(config, files, svc.Program, svc.Args...)
+	} else if svc.Packet() {
+		i, err = inetd.ListenPacketAll(config, svc.Addrs(), svc.Program, svc.Args...)
+	} else {
+		i, err = inetd.ListenAll(config, svc.Addrs(), svc.Program, svc.Args...)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("%s: listening on %s", svc.Name, i.Addrs())
+	s.services[svc.Name] = &running{svc: svc, inetd: i}
+	return nil
+}
+
+// restart replaces a running service with new settings. When the
+// addresses are the same the sockets are taken over like Upgrade does,
+// binding them again would fail while a Wait mode child still holds
+// them and would reset connections waiting to be accepted. If the new
+// settings fail to start the previous ones are restored on the same
+// sockets.
+func (s *Supervisor) restart(r *running, svc Service) error {
+	var files []*os.File
+	if r.svc.FDName == "" {
+		var err error
+		if files, err = r.inetd.Files(); err != nil {
+			return err
+		}
+		defer func() {
+			for _, f := range files {
+				f.Close()
+			}
+		}()
+	}
+
+	var reuse []*os.File
+	if svc.FDName == "" && svc.socketKey() == r.svc.socketKey() {
+		r.inetd.Handoff()
+		reuse = files
+	} else if strings.HasPrefix(r.svc.Network, "unix") {
+		// Closing unlinks the paths, they must be bound again.
+		files = nil
+	}
+	s.retire(svc.Name)
+
+	err := s.start(svc, reuse)
+	if err != nil {
+		if err := s.start(r.svc, files); err != nil {
+			log.Printf("%s: restoring previous config failed: %s", svc.Name, err)
+		}
+	}
+	return err
+}
+
+// retire stops a service from accepting new connections.
+func (s *Supervisor) retire(name string) {
+	r := s.services[name]
+	delete(s.services, name)
+	if err := r.inetd.Close(); err != nil {
+		log.Printf("%s: close failed: %s", name, err)
+	}
+	log.Printf("%s: stopped listening on %s", name, r.inetd.Addrs())
+
+	s.mu.Lock()
+	s.retired = append(s.retired, r.inetd)
+	s.mu.Unlock()
+	s.draining.Add(1)
+	go s.drain(name, r.inetd)
+}
+
+// drain forgets a retired service once its children exit.
+func (s *Supervisor) drain(name string, i *inetd.Inetd) {
+	defer s.draining.Done()
+	if err := i.Drain(context.Background()); err != nil {
+		log.Printf("%s: drain failed: %s", name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for n, r := range s.retired {
+		if r == i {
+			s.retired = append(s.retired[:n], s.retired[n+1:]...)
+			break
+		}
+	}
+}
+
+func (s *Supervisor) Status() {
+	var names []string
+	for name := range s.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		r := s.services[name]
+		log.Printf("%s: listening on %s with %d active, %d rejected",
+			name, r.inetd.Addrs(), r.inetd.Active(), r.inetd.Rejected())
+	}
+	draining := 0
+	s.mu.Lock()
+	for _, i := range s.retired {
+		draining += i.Active()
+	}
+	s.mu.Unlock()
+	log.Printf("%d active in retired services", draining)
+}
+
+// serveMetrics serves Prometheus metrics, reusing the listener passed
+// by an upgrade if there is one.
+func (s *Supervisor) serveMetrics(addr string) error {
+	var err error
+	if files := s.upgraded[metricsKey]; len(files) != 0 {
+		s.metricsListener, err = net.FileListener(files[0])
+	} else {
+		s.metricsListener, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.metrics)
+	s.server = &http.Server{Handler: mux}
+	go func() {
+		err := s.server.Serve(s.metricsListener)
+		if err != http.ErrServerClosed {
+			log.Fatalln("Serving metrics failed:", err)
+		}
+	}()
+	return nil
+}
+
+// Drain waits for the children of retired services to exit on their
+// own, shutting them down if they are still running after -drain.
+func (s *Supervisor) Drain() {
+	done := make(chan struct{})
+	go func() {
+		s.draining.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(*drain):
+		log.Printf("Children still running after %s, shutting down", *drain)
+		s.Shutdown()
+	}
+}
+
+// Shutdown stops all services, including retired ones, in parallel.
+func (s *Supervisor) Shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	s.mu.Lock()
+	all := append([]*inetd.Inetd(nil), s.retired...)
+	s.mu.Unlock()
+	for _, r := range s.services {
+		all = append(all, r.inetd)
+	}
+
+	done := make(chan struct{})
+	for _, i := range all {
+		go func(i *inetd.Inetd) {
+			if err := i.Shutdown(ctx); err != nil {
+				log.Printf("%s: shutdown failed: %s", i.Addr(), err)
+			}
+			done <- struct{}{}
+		}(i)
+	}
+	for range all {
+		<-done
+	}
+}
+
+func observer(name string) inetd.Observer {
+	return inetd.ObserverFunc(func(e inetd.Event) {
+		switch e := e.(type) {
+		case inetd.AcceptEvent, inetd.StartEvent:
+			if !*debug {
+				return
+			}
+		case inetd.ExitEvent:
+			if e.Err == nil && !*debug {
+				return
+			}
+		}
+		log.Printf("%s: %s", name, e)
+	})
+}
@@ -0,0 +1,137 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, name string, services ...Service) {
+	data, err := json.Marshal(Config{Services: services})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "inetd.json")
+	svc := Service{
+		Name:    "sleep",
+		Network: "tcp",
+		Address: "127.0.0.1:0",
+		Program: "sleep",
+		Args:    []string{"60"},
+		Mode:    "wait",
+	}
+	writeConfig(t, name, svc)
+
+	s := newSupervisor()
+	if err := s.Load(name); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown()
+	addr := s.services["sleep"].inetd.Addr().String()
+
+	// The child holds the socket without accepting the connection.
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for s.services["sleep"].inetd.Active() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, change := range []struct {
+		desc  string
+		args  []string
+		cert  string
+		check func(r *running)
+	}{
+		{"new args", []string{"61"}, "", func(r *running) {
+			if r.svc.Args[0] != "61" {
+				t.Errorf("still running with %q", r.svc.Args)
+			}
+		}},
+		{"failed start", []string{"62"}, "/nonexistent", func(r *running) {
+			if r.svc.Args[0] != "61" {
+				t.Errorf("previous config not kept, running with %q", r.svc.Args)
+			}
+		}},
+	} {
+		svc.Args = change.args
+		svc.TLSCert, svc.TLSKey = change.cert, change.cert
+		writeConfig(t, name, svc)
+		if err := s.Load(name); err != nil {
+			t.Fatal(err)
+		}
+
+		r, ok := s.services["sleep"]
+		if !ok {
+			t.Fatalf("%s: service removed", change.desc)
+		}
+		change.check(r)
+		if got := r.inetd.Addr().String(); got != addr {
+			t.Errorf("%s: listening on %s, expected %s", change.desc, got, addr)
+		}
+
+		// The pending connection is still waiting, not reset.
+		c.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if _, err := c.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("%s: pending connection lost: %v", change.desc, err)
+		}
+	}
+}
+
+func TestLoadUpgrading(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "inetd.json")
+	writeConfig(t, name, Service{
+		Name:    "echo",
+		Network: "tcp",
+		Address: "127.0.0.1:0",
+		Program: "cat",
+	}, Service{
+		Name:    "broken",
+		Network: "tcp",
+		Address: "127.0.0.1:0",
+		Program: "cat",
+		TLSCert: "/nonexistent",
+		TLSKey:  "/nonexistent",
+	})
+
+	// Only logged normally, the old process must not retire its
+	// services when upgrading.
+	s := newSupervisor()
+	defer s.Shutdown()
+	if err := s.Load(name); err != nil {
+		t.Errorf("failed start not ignored: %v", err)
+	}
+
+	s = newSupervisor()
+	defer s.Shutdown()
+	s.upgrading = true
+	if err := s.Load(name); err == nil {
+		t.Error("failed start ignored while upgrading")
+	}
+}
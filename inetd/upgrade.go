@@ -0,0 +1,199 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// upgradeEnv describes the files passed to a re-exec'd inetd.
+const upgradeEnv = "INETD_UPGRADE"
+
+// metricsKey names the metrics listener among the upgrade sockets.
+const metricsKey = "metrics"
+
+// upgradeFiles lists the files passed starting at fd 3: sockets
+// inherited from systemd by name followed by listening sockets keyed by
+// socketKey. The readiness pipe comes last, the new process writes a
+// line to it once its services are running.
+type upgradeFiles struct {
+	FDNames []string `json:"fdnames"`
+	Sockets []string `json:"sockets"`
+}
+
+// Upgrade starts a new copy of the binary with the same arguments,
+// handing over all listening sockets, and waits for it to start its
+// services. Once it does our services are retired, their children keep
+// running until they exit.
+func (s *Supervisor) Upgrade() error {
+	var (
+		desc  upgradeFiles
+		files []*os.File
+		dups  []*os.File // closed once passed on
+	)
+	defer func() {
+		for _, f := range dups {
+			f.Close()
+		}
+	}()
+
+	for name, fs := range s.inherited {
+		for _, f := range fs {
+			desc.FDNames = append(desc.FDNames, name)
+			files = append(files, f)
+		}
+	}
+	for _, r := range s.services {
+		if r.svc.FDName != "" {
+			continue
+		}
+		fs, err := r.inetd.Files()
+		if err != nil {
+			return fmt.Errorf("%s: %w", r.svc.Name, err)
+		}
+		for _, f := range fs {
+			desc.Sockets = append(desc.Sockets, r.svc.socketKey())
+			dups = append(dups, f)
+		}
+	}
+	if s.metricsListener != nil {
+		tl, ok := s.metricsListener.(*net.TCPListener)
+		if !ok {
+			return fmt.Errorf("metrics: cannot pass on %T", s.metricsListener)
+		}
+		f, err := tl.File()
+		if err != nil {
+			return fmt.Errorf("metrics: %w", err)
+		}
+		desc.Sockets = append(desc.Sockets, metricsKey)
+		dups = append(dups, f)
+	}
+
+	env, err := json.Marshal(desc)
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	ready, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(append(files, dups...), w)
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, upgradeEnv+"=") {
+			cmd.Env = append(cmd.Env, e)
+		}
+	}
+	cmd.Env = append(cmd.Env, upgradeEnv+"="+string(env))
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return err
+	}
+
+	if _, err := bufio.NewReader(ready).ReadString('\n'); err != nil {
+		cmd.Wait()
+		return errors.New("new process exited before starting services")
+	}
+	// Reap the new process if it exits before we do.
+	go cmd.Wait()
+
+	log.Printf("Upgraded to process %d", cmd.Process.Pid)
+	if err := notify(fmt.Sprintf("MAINPID=%d", cmd.Process.Pid)); err != nil {
+		log.Println("Notifying systemd failed:", err)
+	}
+	for name, r := range s.services {
+		// The new process is listening on the same unix socket paths.
+		r.inetd.Handoff()
+		s.retire(name)
+	}
+	if s.server != nil {
+		s.server.Close()
+	}
+	return nil
+}
+
+// inheritUpgrade collects the files passed by Upgrade, if any, and
+// returns the readiness pipe.
+func (s *Supervisor) inheritUpgrade() (*os.File, error) {
+	env := os.Getenv(upgradeEnv)
+	if env == "" {
+		return nil, nil
+	}
+	os.Unsetenv(upgradeEnv)
+
+	var desc upgradeFiles
+	if err := json.Unmarshal([]byte(env), &desc); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", upgradeEnv, err)
+	}
+
+	fd := 3
+	next := func(name string) *os.File {
+		syscall.CloseOnExec(fd)
+		f := os.NewFile(uintptr(fd), name)
+		fd++
+		return f
+	}
+	for _, name := range desc.FDNames {
+		s.inherited[name] = append(s.inherited[name], next(name))
+	}
+	for _, key := range desc.Sockets {
+		s.upgraded[key] = append(s.upgraded[key], next(key))
+	}
+	return next("ready"), nil
+}
+
+// closeUpgraded releases sockets passed by Upgrade, any still in use
+// have been duplicated by the services listening on them.
+func (s *Supervisor) closeUpgraded() {
+	for key, files := range s.upgraded {
+		for _, f := range files {
+			f.Close()
+		}
+		delete(s.upgraded, key)
+	}
+}
+
+// notify sends state to systemd's notification socket, if there is one.
+func notify(state string) error {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return nil
+	}
+	c, err := net.Dial("unixgram", name)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	_, err = c.Write([]byte(state))
+	return err
+}
@@ -0,0 +1,176 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftputil
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/secsy/goftp"
+)
+
+// Entry is a listed path with everything the server said about it.
+// Walkers and FindFiles describe everything as an *Entry, except a root
+// the server cannot stat. Sys returns the raw listing line as in goftp.
+type Entry struct {
+	fs.FileInfo
+	name string
+
+	// Facts from MLSD or MLST by lower case name with values as sent,
+	// such as "type", "unique", "perm" and "media-type". Empty for
+	// servers which only support LIST.
+	Facts map[string]string
+	// Hashes of the content reported by the server as lower case hex
+	// by algorithm name: "MD5", "SHA-1", "SHA-256" or "SHA-512".
+	Hashes map[string]string
+	// Target of a symbolic link, if the server reported it.
+	Target string
+}
+
+// hashFacts maps MLSD facts holding a bare hash to the algorithm.
+var hashFacts = map[string]string{
+	"md5":     "MD5",
+	"xmd5":    "MD5",
+	"xsha1":   "SHA-1",
+	"xsha256": "SHA-256",
+	"xsha512": "SHA-512",
+}
+
+// hashCommands are the commands predating HASH for each algorithm.
+var hashCommands = map[string]string{
+	"MD5":     "XMD5",
+	"SHA-1":   "XSHA1",
+	"SHA-256": "XSHA256",
+	"SHA-512": "XSHA512",
+}
+
+// hashSizes is the length of each algorithm's hashes in hex.
+var hashSizes = map[string]int{
+	"MD5":     32,
+	"SHA-1":   40,
+	"SHA-256": 64,
+	"SHA-512": 128,
+}
+
+// NewEntry parses the raw listing line behind a goftp FileInfo, goftp
+// lower cases facts and mangles the names of links from LIST.
+func NewEntry(info fs.FileInfo) *Entry {
+	if e, ok := info.(*Entry); ok {
+		return e
+	}
+	e := &Entry{FileInfo: info, name: info.Name()}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		e.name, e.Target = parseLink(info)
+	}
+
+	// Facts never contain spaces, LIST lines do.
+	raw, _ := info.Sys().(string)
+	facts, _, ok := strings.Cut(raw, "; ")
+	if !ok || strings.Contains(facts, " ") {
+		return e
+	}
+	e.Facts = make(map[string]string)
+	for _, fact := range strings.Split(facts, ";") {
+		if key, value, ok := strings.Cut(fact, "="); ok {
+			e.Facts[strings.ToLower(key)] = value
+		}
+	}
+
+	for fact, algorithm := range hashFacts {
+		if value := e.Facts[fact]; value != "" {
+			e.addHash(algorithm, value)
+		}
+	}
+	// The HASH extension's fact names the algorithm, "SHA-256:0a1b..."
+	if algorithm, value, ok := strings.Cut(e.Facts["hash"], ":"); ok {
+		e.addHash(strings.ToUpper(algorithm), value)
+	}
+	return e
+}
+
+func (e *Entry) addHash(algorithm, value string) {
+	if e.Hashes == nil {
+		e.Hashes = make(map[string]string)
+	}
+	e.Hashes[algorithm] = strings.ToLower(value)
+}
+
+func (e *Entry) Name() string {
+	return e.name
+}
+
+// Unique returns the server's identifier for the file, if any. Paths
+// with the same identifier are the same file.
+func (e *Entry) Unique() string {
+	return e.Facts["unique"]
+}
+
+// Perm returns the RFC 3659 permissions the client has, such as "rw".
+func (e *Entry) Perm() string {
+	return e.Facts["perm"]
+}
+
+// MediaType returns the file's MIME type, if the server knows it.
+func (e *Entry) MediaType() string {
+	return e.Facts["media-type"]
+}
+
+// ErrNoHash means the server could not provide a hash.
+var ErrNoHash = errors.New("no hash available")
+
+// FetchHash asks the server for the hash of a file using the HASH
+// command or, failing that, the older XMD5 style commands. The result is
+// lower case hex, algorithm is one of those used by Entry.Hashes. Errors
+// other than from the connection itself wrap ErrNoHash.
+func FetchHash(conn goftp.RawConn, name, algorithm string) (string, error) {
+	size, ok := hashSizes[algorithm]
+	if !ok {
+		return "", fmt.Errorf("%w: unsupported hash algorithm %q", ErrNoHash, algorithm)
+	}
+
+	code, msg, err := conn.SendCommand("OPTS HASH %s", algorithm)
+	if err == nil && code == 200 {
+		code, msg, err = conn.SendCommand("HASH %s", name)
+		if err == nil && code == 213 {
+			return findHash(msg, size)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	code, msg, err = conn.SendCommand("%s %s", hashCommands[algorithm], name)
+	if err != nil {
+		return "", err
+	}
+	if code != 250 && code != 251 {
+		return "", fmt.Errorf("%s %s: %w: %d %s",
+			hashCommands[algorithm], name, ErrNoHash, code, msg)
+	}
+	return findHash(msg, size)
+}
+
+// findHash picks the hash out of a reply, servers vary in what else
+// they include such as the name and byte range.
+func findHash(msg string, size int) (string, error) {
+	for _, field := range strings.Fields(msg) {
+		if len(field) == size && strings.Trim(strings.ToLower(field), "0123456789abcdef") == "" {
+			return strings.ToLower(field), nil
+		}
+	}
+	return "", fmt.Errorf("%w in response: %s", ErrNoHash, msg)
+}
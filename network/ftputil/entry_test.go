@@ -0,0 +1,125 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftputil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestNewEntry(t *testing.T) {
+	e := NewEntry(rawInfo{"iso", 0, "type=file;size=3;modify=20200101000000;" +
+		"UNIQUE=803U1A;perm=adfrw;media-type=application/x-iso9660-image;" +
+		"HASH=SHA-256:9F86D081884C7D659A2FEAA0C55AD015A3BF4F1B2B0B822CD15D6C15B0F00A08;" +
+		"X.MD5=ignored;XMD5=098F6BCD4621D373CADE4E832627B4F6; debian.iso"})
+
+	if e.Name() != "iso" {
+		t.Errorf("got name %q, expected iso", e.Name())
+	}
+	for _, test := range []struct{ got, expect string }{
+		{e.Unique(), "803U1A"},
+		{e.Perm(), "adfrw"},
+		{e.MediaType(), "application/x-iso9660-image"},
+		{e.Facts["type"], "file"},
+		{e.Hashes["MD5"], "098f6bcd4621d373cade4e832627b4f6"},
+		{e.Hashes["SHA-256"], "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"},
+	} {
+		if test.got != test.expect {
+			t.Errorf("got %q, expected %q", test.got, test.expect)
+		}
+	}
+	if len(e.Hashes) != 2 {
+		t.Errorf("got hashes %q, expected MD5 and SHA-256", e.Hashes)
+	}
+	if NewEntry(e) != e {
+		t.Error("NewEntry did not reuse an Entry")
+	}
+
+	list := NewEntry(rawInfo{"debian.iso", 0,
+		"-rw-r--r--   1 ftp      ftp             3 Jan  1  2020 debian.iso"})
+	if list.Facts != nil || list.Hashes != nil {
+		t.Errorf("got facts %q from LIST", list.Facts)
+	}
+}
+
+// hashConn answers hash commands with canned replies.
+type hashConn struct {
+	replies map[string]string
+	sent    []string
+	err     error // returned for every command if set
+}
+
+func (c *hashConn) SendCommand(f string, args ...interface{}) (int, string, error) {
+	cmd := fmt.Sprintf(f, args...)
+	c.sent = append(c.sent, cmd)
+	if c.err != nil {
+		return 0, "", c.err
+	}
+	reply, ok := c.replies[cmd]
+	if !ok {
+		return 502, "Command not implemented", nil
+	}
+	var code int
+	fmt.Sscanf(reply, "%d", &code)
+	return code, reply[4:], nil
+}
+
+func (c *hashConn) PrepareDataConn() (func() (net.Conn, error), error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *hashConn) ReadResponse() (int, string, error) {
+	return 0, "", errors.New("not implemented")
+}
+
+func (c *hashConn) Close() error {
+	return nil
+}
+
+func TestFetchHash(t *testing.T) {
+	const md5 = "098f6bcd4621d373cade4e832627b4f6"
+	for _, replies := range []map[string]string{
+		{
+			"OPTS HASH MD5":  "200 MD5",
+			"HASH /test.txt": "213 MD5 0-3 098F6BCD4621D373CADE4E832627B4F6 /test.txt",
+		},
+		{"XMD5 /test.txt": "250 098f6bcd4621d373cade4e832627b4f6"},
+		{"XMD5 /test.txt": "251 /test.txt 098f6bcd4621d373cade4e832627b4f6"},
+	} {
+		conn := &hashConn{replies: replies}
+		sum, err := FetchHash(conn, "/test.txt", "MD5")
+		if err != nil {
+			t.Errorf("%q: %v", conn.sent, err)
+		} else if sum != md5 {
+			t.Errorf("%q: got %q, expected %q", conn.sent, sum, md5)
+		}
+	}
+
+	conn := &hashConn{}
+	if _, err := FetchHash(conn, "/test.txt", "MD5"); !errors.Is(err, ErrNoHash) {
+		t.Errorf("%q: unsupported server returned %v", conn.sent, err)
+	}
+	if _, err := FetchHash(conn, "/test.txt", "CRC32"); !errors.Is(err, ErrNoHash) {
+		t.Errorf("unsupported algorithm returned %v", err)
+	}
+
+	conn = &hashConn{err: io.ErrUnexpectedEOF}
+	if _, err := FetchHash(conn, "/test.txt", "MD5"); err != io.ErrUnexpectedEOF {
+		t.Errorf("broken connection returned %v", err)
+	}
+}
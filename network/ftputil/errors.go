@@ -0,0 +1,70 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftputil
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/secsy/goftp"
+)
+
+// PathError records a failure to list a path.
+type PathError struct {
+	Path string
+	// Code is the FTP reply code, 0 if the error was not a reply.
+	Code int
+	Err  error
+}
+
+func newPathError(path string, err error) *PathError {
+	e := &PathError{Path: path, Err: err}
+	var ferr goftp.Error
+	if errors.As(err, &ferr) {
+		e.Code = ferr.Code()
+	}
+	return e
+}
+
+func (e *PathError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *PathError) Unwrap() error {
+	return e.Err
+}
+
+// WalkError lists every path skipped by a walk using ErrorSkip.
+type WalkError []*PathError
+
+func (e WalkError) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	var msgs []string
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d paths failed: %s", len(e), strings.Join(msgs, "; "))
+}
+
+func (e WalkError) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
@@ -0,0 +1,66 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftputil
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+)
+
+// replyError mimics the errors goftp returns for server replies.
+type replyError struct{ code int }
+
+func (e replyError) Error() string   { return "unexpected response" }
+func (e replyError) Temporary() bool { return false }
+func (e replyError) Code() int       { return e.code }
+func (e replyError) Message() string { return "" }
+
+func TestPathError(t *testing.T) {
+	err := newPathError("/denied", replyError{550})
+	if err.Code != 550 {
+		t.Errorf("got code %d, expected 550", err.Code)
+	}
+	if err.Error() != "/denied: unexpected response" {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+
+	err = newPathError("/gone", fs.ErrNotExist)
+	if err.Code != 0 {
+		t.Errorf("got code %d, expected 0", err.Code)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("%v does not wrap %v", err, fs.ErrNotExist)
+	}
+}
+
+func TestWalkError(t *testing.T) {
+	var err error = WalkError{
+		newPathError("/a", replyError{550}),
+		newPathError("/b", fs.ErrNotExist),
+	}
+	expect := "2 paths failed: /a: unexpected response; /b: file does not exist"
+	if err.Error() != expect {
+		t.Errorf("got %q, expected %q", err.Error(), expect)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("%v does not wrap %v", err, fs.ErrNotExist)
+	}
+
+	var perr *PathError
+	if !errors.As(err, &perr) || perr.Path != "/a" {
+		t.Errorf("got %v, expected /a", perr)
+	}
+}
@@ -15,37 +15,30 @@
 package ftputil
 
 import (
-	"os"
-	"path"
+	"io/fs"
 
 	"github.com/secsy/goftp"
 )
 
-// Find all files under a given path on an FTP server.  Aborts on any error.
-// May want to skip inaccessable directories and similar things in the future.
-func FindFiles(client *goftp.Client, root string) (map[string]os.FileInfo, error) {
-	entries, err := client.ReadDir(root)
+// Find all files under a given path on an FTP server.  Aborts on any error,
+// a Walker with ErrorSkip can skip inaccessible directories instead.
+// The whole listing is held in memory, large trees should use Walk.
+func FindFiles(client *goftp.Client, root string) (map[string]*Entry, error) {
+	files := make(map[string]*Entry)
+	err := Walk(client, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files[name] = NewEntry(info)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	files := make(map[string]os.FileInfo)
-	for _, entry := range entries {
-		if entry.IsDir() {
-			if entry.Name() == "." || entry.Name() == ".." {
-				continue
-			}
-			subfiles, err := FindFiles(client, path.Join(root, entry.Name()))
-			if err != nil {
-				return nil, err
-			}
-			for subpath, subentry := range subfiles {
-				files[subpath] = subentry
-			}
-		} else {
-			files[path.Join(root, entry.Name())] = entry
-		}
-	}
-
 	return files, nil
 }
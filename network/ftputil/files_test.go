@@ -15,6 +15,7 @@
 package ftputil
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -40,4 +41,7 @@ func TestFindFiles(t *testing.T) {
 	if pem.Size() != 2803 {
 		t.Errorf("ftpd.pem has size %d, expected 2803", pem.Size())
 	}
+	if !strings.EqualFold(pem.Facts["type"], "file") {
+		t.Errorf("ftpd.pem has type fact %q, expected file", pem.Facts["type"])
+	}
 }
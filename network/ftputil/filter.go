@@ -0,0 +1,192 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftputil
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Pattern selects paths relative to the root of a walk.
+type Pattern struct {
+	expr string
+	// glob is split into path elements, nil for regular expressions.
+	glob     []string
+	anchored bool // glob contains a slash
+	dirOnly  bool // glob ends with a slash
+	re       *regexp.Regexp
+}
+
+// Glob parses an rsync style pattern. Without a slash it matches the
+// last element of paths at any depth, otherwise the whole path from the
+// root, a leading slash is optional. A trailing slash only matches
+// directories. Elements use path.Match syntax and "**" matches any
+// number of elements, including none.
+func Glob(pattern string) (*Pattern, error) {
+	p := &Pattern{expr: pattern}
+	if strings.HasSuffix(pattern, "/") {
+		p.dirOnly = true
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	p.anchored = strings.Contains(pattern, "/")
+	p.glob = strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+	for _, elem := range p.glob {
+		if _, err := path.Match(elem, ""); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Regexp parses a regular expression matched against paths relative to
+// the root, such as "pub/linux/README". Since what a regular expression
+// could match below a directory is unknown, one used as an include stops
+// the walk from skipping any directories: the whole tree is listed and
+// only matching entries are reported. Prefer globs for large trees.
+func Regexp(expr string) (*Pattern, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &Pattern{expr: expr, re: re}, nil
+}
+
+func (p *Pattern) String() string {
+	return p.expr
+}
+
+// Globs collects patterns given by repeating a command line flag.
+type Globs []*Pattern
+
+func (g *Globs) String() string {
+	var exprs []string
+	for _, p := range *g {
+		exprs = append(exprs, p.expr)
+	}
+	return strings.Join(exprs, " ")
+}
+
+func (g *Globs) Set(pattern string) error {
+	p, err := Glob(pattern)
+	if err != nil {
+		return err
+	}
+	*g = append(*g, p)
+	return nil
+}
+
+// Regexps is like Globs for regular expressions.
+type Regexps []*Pattern
+
+func (r *Regexps) String() string {
+	return (*Globs)(r).String()
+}
+
+func (r *Regexps) Set(expr string) error {
+	p, err := Regexp(expr)
+	if err != nil {
+		return err
+	}
+	*r = append(*r, p)
+	return nil
+}
+
+// Match reports whether the pattern matches the relative path.
+func (p *Pattern) Match(name string, dir bool) bool {
+	if p.re != nil {
+		return p.re.MatchString(name)
+	}
+	if p.dirOnly && !dir {
+		return false
+	}
+	if !p.anchored {
+		return matchGlob(p.glob, []string{path.Base(name)})
+	}
+	return matchGlob(p.glob, strings.Split(name, "/"))
+}
+
+// within reports whether paths below the directory could match.
+func (p *Pattern) within(dir string) bool {
+	if p.re != nil || !p.anchored {
+		return true
+	}
+	return matchPrefix(p.glob, strings.Split(dir, "/"))
+}
+
+func matchGlob(glob, elems []string) bool {
+	if len(glob) == 0 {
+		return len(elems) == 0
+	}
+	if glob[0] == "**" {
+		for n := 0; n <= len(elems); n++ {
+			if matchGlob(glob[1:], elems[n:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(elems) == 0 {
+		return false
+	}
+	ok, _ := path.Match(glob[0], elems[0])
+	return ok && matchGlob(glob[1:], elems[1:])
+}
+
+// matchPrefix reports whether elems match the start of glob with some of
+// the pattern left over for what lies below.
+func matchPrefix(glob, elems []string) bool {
+	if len(elems) == 0 {
+		return len(glob) != 0
+	}
+	if len(glob) == 0 {
+		return false
+	}
+	if glob[0] == "**" {
+		return true
+	}
+	ok, _ := path.Match(glob[0], elems[0])
+	return ok && matchPrefix(glob[1:], elems[1:])
+}
+
+// filter reports whether to visit d, marking directories which match an
+// include so everything below them is included too.
+func (w *Walker) filter(d *dir) bool {
+	isDir := d.entry.IsDir()
+	for _, p := range w.Exclude {
+		if p.Match(d.rel, isDir) {
+			return false
+		}
+	}
+	if d.included {
+		return true
+	}
+
+	for _, p := range w.Include {
+		if p.Match(d.rel, isDir) {
+			d.included = true
+			return true
+		}
+	}
+	if isDir {
+		// Keep directories which may lead to an include.
+		for _, p := range w.Include {
+			if p.within(d.rel) {
+				return true
+			}
+		}
+	}
+	return false
+}
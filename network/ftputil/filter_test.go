@@ -0,0 +1,212 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftputil
+
+import (
+	"io/fs"
+	"os"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestGlob(t *testing.T) {
+	for _, test := range []struct {
+		glob  string
+		name  string
+		dir   bool
+		match bool
+	}{
+		{"*.iso", "debian.iso", false, true},
+		{"*.iso", "pub/debian/debian.iso", false, true},
+		{"*.iso", "pub/debian.iso/README", false, false},
+		{"pub/*.iso", "pub/debian.iso", false, true},
+		{"pub/*.iso", "pub/debian/debian.iso", false, false},
+		{"/pub/*.iso", "pub/debian.iso", false, true},
+		{"pub/**/*.iso", "pub/debian.iso", false, true},
+		{"pub/**/*.iso", "pub/debian/12/debian.iso", false, true},
+		{"pub/**", "pub/debian/12", true, true},
+		{"pub/**", "pub", true, true},
+		{"pub/**", "src/pub", true, false},
+		{"tmp/", "tmp", true, true},
+		{"tmp/", "tmp", false, false},
+		{"a/tmp/", "a/tmp", true, true},
+	} {
+		p, err := Glob(test.glob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m := p.Match(test.name, test.dir); m != test.match {
+			t.Errorf("%q matching %q got %v, expected %v",
+				test.glob, test.name, m, test.match)
+		}
+	}
+
+	if _, err := Glob("pub/[a"); err == nil {
+		t.Error("Glob accepted a bad pattern")
+	}
+}
+
+func TestPatternWithin(t *testing.T) {
+	for _, test := range []struct {
+		glob   string
+		dir    string
+		within bool
+	}{
+		{"*.iso", "anything", true},
+		{"pub/debian/**", "pub", true},
+		{"pub/debian/**", "pub/debian", true},
+		{"pub/debian/**", "pub/debian/12", true},
+		{"pub/debian/**", "src", false},
+		{"pub/debian/**", "pub/ubuntu", false},
+		{"pub/*/README", "pub/debian", true},
+		{"pub/*/README", "pub/debian/12", false},
+		{"pub/debian", "pub/debian", false},
+	} {
+		p, err := Glob(test.glob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w := p.within(test.dir); w != test.within {
+			t.Errorf("%q within %q got %v, expected %v",
+				test.glob, test.dir, w, test.within)
+		}
+	}
+
+	p, err := Regexp(`^pub/.*\.iso$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.within("src") {
+		t.Error("regular expressions must not prune directories")
+	}
+	if !p.Match("pub/debian/debian.iso", false) {
+		t.Errorf("%s did not match", p)
+	}
+}
+
+// visitTree drives visit over tree like walk does, returning the paths
+// passed to fn and the directories listed.
+func visitTree(t *testing.T, w *Walker, tree fstest.MapFS) (visited, listed []string) {
+	fn := func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			t.Fatal(err)
+		}
+		visited = append(visited, name)
+		return nil
+	}
+
+	pending := []dir{{path: ".", real: ".", included: len(w.Include) == 0}}
+	for len(pending) != 0 {
+		d := pending[0]
+		pending = pending[1:]
+		listed = append(listed, d.path)
+
+		entries, err := fs.ReadDir(tree, d.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var infos []os.FileInfo
+		for _, e := range entries {
+			info, err := e.Info()
+			if err != nil {
+				t.Fatal(err)
+			}
+			infos = append(infos, info)
+		}
+
+		dirs, err := w.visit(listing{dir: d, infos: infos}, fn, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pending = append(dirs, pending...)
+	}
+	return visited, listed
+}
+
+func TestWalkerFilter(t *testing.T) {
+	tree := fstest.MapFS{
+		"pub/debian/README":         {},
+		"pub/debian/12/debian.iso":  {},
+		"pub/debian/tmp/debian.iso": {},
+		"pub/ubuntu/ubuntu.iso":     {},
+		"src/debian.iso":            {},
+	}
+	include, _ := Glob("pub/debian/**")
+	exclude, _ := Glob("tmp/")
+
+	for _, test := range []struct {
+		name    string
+		w       Walker
+		visited []string
+		listed  []string
+	}{
+		{
+			name: "include",
+			w: Walker{
+				Include: []*Pattern{include},
+				Exclude: []*Pattern{exclude},
+			},
+			visited: []string{
+				"pub",
+				"pub/debian",
+				"pub/debian/12",
+				"pub/debian/README",
+				"pub/debian/12/debian.iso",
+			},
+			listed: []string{".", "pub", "pub/debian", "pub/debian/12"},
+		},
+		{
+			name: "depth",
+			w:    Walker{MaxDepth: 2},
+			visited: []string{
+				"pub",
+				"src",
+				"pub/debian",
+				"pub/ubuntu",
+				"src/debian.iso",
+			},
+			listed: []string{".", "pub", "src"},
+		},
+	} {
+		visited, listed := visitTree(t, &test.w, tree)
+		if !reflect.DeepEqual(visited, test.visited) {
+			t.Errorf("%s: visited %q, expected %q", test.name, visited, test.visited)
+		}
+		if !reflect.DeepEqual(listed, test.listed) {
+			t.Errorf("%s: listed %q, expected %q", test.name, listed, test.listed)
+		}
+	}
+}
+
+func TestWalkerNegativeDepth(t *testing.T) {
+	w := Walker{MaxDepth: -1}
+	if err := w.Walk("/", nil); err == nil {
+		t.Error("Walk accepted a negative MaxDepth")
+	}
+}
+
+func TestRegexps(t *testing.T) {
+	var r Regexps
+	if err := r.Set(`\.iso$`); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Set(`[`); err == nil {
+		t.Error("Set accepted a bad expression")
+	}
+	if len(r) != 1 || !r[0].Match("pub/debian.iso", false) {
+		t.Errorf("unexpected patterns: %s", r.String())
+	}
+}
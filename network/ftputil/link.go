@@ -0,0 +1,165 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftputil
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// LinkPolicy decides what a Walker does with symbolic links.
+type LinkPolicy int
+
+const (
+	// LinkRecord reports links without following them, as in
+	// filepath.WalkDir. Use LinkTarget to find where they point.
+	LinkRecord LinkPolicy = iota
+	// LinkSkip leaves links out.
+	LinkSkip
+	// LinkFollow reports what links point to under the link's path,
+	// descending into directories. Links which cannot be resolved are
+	// reported as links. Loops are a *PathError wrapping ErrLinkLoop,
+	// handled by the Walker's ErrorPolicy like a failed listing.
+	LinkFollow
+)
+
+// maxLinks bounds the chain of links followed resolving one, like Linux.
+const maxLinks = 40
+
+// ErrLinkLoop means following a link would revisit a directory.
+var ErrLinkLoop = errors.New("symbolic link loop")
+
+// lsLink matches the name of a link in LIST output, "name -> target".
+var lsLink = regexp.MustCompile(`^\s*l\S{9}(?:\s+\S+){3}\s+\d+\s+\w+\s+\d+\s+[\d:]+\s+(.+?) -> (.+)$`)
+
+// followed is what a link in a listing resolved to.
+type followed struct {
+	info fs.FileInfo // nil if the link couldn't be resolved
+	real string
+	err  error
+}
+
+// LinkTarget returns where a link points, if the server said so. Works
+// with links from ReadDir, Stat and those followed by a Walker.
+func LinkTarget(info fs.FileInfo) (string, bool) {
+	e := NewEntry(info)
+	return e.Target, e.Target != ""
+}
+
+// parseLink finds a link's name and target in the raw listing, goftp
+// mangles names from LIST and lower cases MLSD facts.
+func parseLink(info fs.FileInfo) (name, target string) {
+	raw, _ := info.Sys().(string)
+	if m := lsLink.FindStringSubmatch(raw); m != nil {
+		return path.Base(m[1]), m[2]
+	}
+
+	name = info.Name()
+	facts, _, ok := strings.Cut(raw, "; ")
+	if !ok {
+		return name, ""
+	}
+	for _, fact := range strings.Split(facts, ";") {
+		key, value, _ := strings.Cut(fact, "=")
+		if !strings.EqualFold(key, "type") {
+			continue
+		}
+		const slink = "os.unix=slink:"
+		if len(value) > len(slink) && strings.EqualFold(value[:len(slink)], slink) {
+			return name, value[len(slink):]
+		}
+	}
+	return name, ""
+}
+
+// followLinks resolves the links in a directory's listing by name. It
+// is called by the workers since each link takes at least one Stat.
+func (w *Walker) followLinks(parent *dir, infos []os.FileInfo) map[string]followed {
+	var links map[string]followed
+	for n, info := range infos {
+		e := NewEntry(info)
+		infos[n] = e
+		if e.Mode()&fs.ModeSymlink == 0 {
+			continue
+		}
+		if links == nil {
+			links = make(map[string]followed)
+		}
+		links[e.Name()] = w.follow(parent, e)
+	}
+	return links
+}
+
+// follow finds what a link points to, resolving chains of links when
+// the server reports their targets.
+func (w *Walker) follow(parent *dir, link *Entry) followed {
+	real := path.Join(parent.real, link.Name())
+	info := fs.FileInfo(link)
+	for links := 0; ; links++ {
+		if links == maxLinks {
+			return followed{err: ErrLinkLoop}
+		}
+		target, ok := LinkTarget(info)
+		if ok {
+			real = resolveLink(path.Dir(real), target)
+		}
+		next, err := w.Client.Stat(real)
+		if err != nil {
+			// Broken or unreadable, report the link as is.
+			return followed{}
+		}
+		if next.Mode()&fs.ModeSymlink == 0 {
+			info = next
+			break
+		}
+		if !ok {
+			return followed{}
+		}
+		info = next
+	}
+
+	if info.IsDir() && parent.leadsTo(real) {
+		return followed{err: ErrLinkLoop}
+	}
+	e := *NewEntry(info)
+	e.name = link.Name()
+	e.Target = link.Target
+	return followed{info: &e, real: real}
+}
+
+// leadsTo reports whether real is d, one of the directories above it
+// or above one of those, meaning descending into real would loop.
+func (d *dir) leadsTo(real string) bool {
+	if real == "/" {
+		return true
+	}
+	for ; d != nil; d = d.up {
+		if d.real == real || strings.HasPrefix(d.real, real+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func resolveLink(dir, target string) string {
+	if path.IsAbs(target) {
+		return path.Clean(target)
+	}
+	return path.Join(dir, target)
+}
@@ -0,0 +1,157 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftputil
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// rawInfo is a listing entry as goftp would parse it.
+type rawInfo struct {
+	name string
+	mode fs.FileMode
+	raw  string
+}
+
+func (r rawInfo) Name() string       { return r.name }
+func (r rawInfo) Size() int64        { return 0 }
+func (r rawInfo) Mode() fs.FileMode  { return r.mode }
+func (r rawInfo) ModTime() time.Time { return time.Time{} }
+func (r rawInfo) IsDir() bool        { return r.mode.IsDir() }
+func (r rawInfo) Sys() interface{}   { return r.raw }
+
+func TestLinkTarget(t *testing.T) {
+	for _, test := range []struct {
+		info   rawInfo
+		name   string
+		target string
+	}{
+		{rawInfo{"link", fs.ModeSymlink,
+			"type=OS.unix=slink:../Pub/Debian;size=13;modify=20200101000000; link"},
+			"link", "../Pub/Debian"},
+		{rawInfo{"link", fs.ModeSymlink,
+			"type=OS.unix=symlink;size=13;modify=20200101000000; link"},
+			"link", ""},
+		// goftp names LIST links after the end of the target.
+		{rawInfo{"Debian", fs.ModeSymlink,
+			"lrwxrwxrwx   1 ftp      ftp            13 Jan  1  2020 link -> ../Pub/Debian"},
+			"link", "../Pub/Debian"},
+		{rawInfo{"debian", 0,
+			"-rw-r--r--   1 ftp      ftp            13 Jan  1  2020 a -> debian"},
+			"debian", ""},
+	} {
+		info := NewEntry(test.info)
+		if info.Name() != test.name {
+			t.Errorf("%q: got name %q, expected %q", test.info.raw, info.Name(), test.name)
+		}
+		target, ok := LinkTarget(info)
+		if target != test.target || ok != (test.target != "") {
+			t.Errorf("%q: got target %q, expected %q", test.info.raw, target, test.target)
+		}
+	}
+}
+
+func TestResolveLink(t *testing.T) {
+	for _, test := range []struct {
+		dir, target, expect string
+	}{
+		{"/pub", "debian", "/pub/debian"},
+		{"/pub", "../src", "/src"},
+		{"/pub", "/mirror/", "/mirror"},
+		{"/pub", ".", "/pub"},
+	} {
+		if real := resolveLink(test.dir, test.target); real != test.expect {
+			t.Errorf("%s in %s resolved to %s, expected %s",
+				test.target, test.dir, real, test.expect)
+		}
+	}
+}
+
+func TestLeadsTo(t *testing.T) {
+	// /a/x is a link to /b so its real path is /b.
+	a := &dir{real: "/a"}
+	x := &dir{real: "/b", up: a}
+	for _, test := range []struct {
+		real  string
+		loops bool
+	}{
+		{"/a", true},
+		{"/b", true},
+		{"/", true},
+		{"/b/c", false},
+		{"/c", false},
+		{"/ab", false},
+	} {
+		if loops := x.leadsTo(test.real); loops != test.loops {
+			t.Errorf("link to %s got %v, expected %v", test.real, loops, test.loops)
+		}
+	}
+}
+
+func TestWalkLinkCycle(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"a", "b"} {
+		if err := os.Mkdir(filepath.Join(root, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Neither link points above itself, only the pair loops.
+	if err := os.Symlink("../b", filepath.Join(root, "a", "x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../a", filepath.Join(root, "b", "y")); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := newTestClient(nil, root, nil)
+	if err != nil {
+		t.Fatal("Test client failed:", err)
+	}
+	defer client.Close()
+
+	var visited []string
+	w := Walker{Client: client.Client, Errors: ErrorSkip, Links: LinkFollow}
+	err = w.Walk("/", func(name string, d fs.DirEntry, err error) error {
+		visited = append(visited, name)
+		return err
+	})
+
+	var skipped WalkError
+	if !errors.As(err, &skipped) {
+		t.Fatalf("expected a WalkError, got %v", err)
+	}
+	var loops []string
+	for _, e := range skipped {
+		if !errors.Is(e, ErrLinkLoop) {
+			t.Errorf("unexpected error: %v", e)
+		}
+		loops = append(loops, e.Path)
+	}
+	sort.Strings(loops)
+	if expect := []string{"/a/x/y", "/b/y/x"}; !reflect.DeepEqual(loops, expect) {
+		t.Errorf("loops at %q, expected %q", loops, expect)
+	}
+	sort.Strings(visited)
+	if expect := []string{"/", "/a", "/a/x", "/b", "/b/y"}; !reflect.DeepEqual(visited, expect) {
+		t.Errorf("visited %q, expected %q", visited, expect)
+	}
+}
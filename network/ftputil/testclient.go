@@ -15,16 +15,21 @@
 package ftputil
 
 import (
+	"context"
 	"crypto/tls"
 	"io"
 	"net/url"
 	"path/filepath"
 	"runtime"
+	"time"
 
 	"github.com/marineam/experiments/network/inetd"
 	"github.com/secsy/goftp"
 )
 
+// How long to wait for ftpd processes to exit before killing them.
+const shutdownTimeout = 5 * time.Second
+
 type TestClient struct {
 	*goftp.Client
 	inetd *inetd.Inetd
@@ -40,7 +45,23 @@ func testDataPath(elem ...string) string {
 }
 
 func NewTestClient(log io.Writer) (*TestClient, error) {
-	inetd, err := inetd.Listen("tcp", "localhost:0", testDataPath("ftpd.sh"), "--tls=3")
+	return NewFaultyTestClient(log, nil)
+}
+
+// NewFaultyTestClient injects faults into the FTP control connection
+// for testing how clients handle misbehaving servers.
+func NewFaultyTestClient(log io.Writer, faults *inetd.Faults) (*TestClient, error) {
+	return newTestClient(log, testDataPath(), faults)
+}
+
+// newTestClient serves dir instead of testdata.
+func newTestClient(log io.Writer, dir string, faults *inetd.Faults) (*TestClient, error) {
+	iconfig := inetd.Config{
+		Env:    []string{"FTP_ANON_DIR=" + dir},
+		Faults: faults,
+	}
+	inetd, err := inetd.ListenConfig(iconfig, "tcp", "localhost:0",
+		testDataPath("pure-ftpd"), "--tls=3")
 	if err != nil {
 		return nil, err
 	}
@@ -74,8 +95,11 @@ func (tc *TestClient) URL() *url.URL {
 }
 
 func (tc *TestClient) Close() error {
-	ierr := tc.inetd.Close()
 	cerr := tc.Client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	ierr := tc.inetd.Shutdown(ctx)
 	if cerr != nil {
 		return cerr
 	} else if ierr != nil {
@@ -0,0 +1,301 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftputil
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/secsy/goftp"
+)
+
+// DefaultWorkers matches goftp's default ConnectionsPerHost.
+const DefaultWorkers = 5
+
+// SkipDir may be returned by a WalkDirFunc, as in filepath.WalkDir.
+var SkipDir = fs.SkipDir
+
+// SkipAll may be returned by a WalkDirFunc to stop walking.
+var SkipAll = fs.SkipAll
+
+// ErrorPolicy decides what a Walker does when listing a directory fails.
+type ErrorPolicy int
+
+const (
+	// ErrorCallback passes a *PathError to the WalkDirFunc which decides
+	// whether to continue, as in filepath.WalkDir.
+	ErrorCallback ErrorPolicy = iota
+	// ErrorAbort stops the walk, returning the *PathError.
+	ErrorAbort
+	// ErrorSkip leaves out the directory and continues, Walk returns
+	// a WalkError listing every directory skipped.
+	ErrorSkip
+)
+
+// Walker lists FTP directory trees, several directories at a time.
+type Walker struct {
+	Client *goftp.Client
+	// Workers limits how many directories are listed in parallel,
+	// defaults to DefaultWorkers. Raise ConnectionsPerHost to match.
+	Workers int
+	Errors  ErrorPolicy
+
+	// Include limits the walk to matching paths and the directories
+	// leading to them, everything below a matching directory is
+	// included. Exclude skips matching paths. Directories which are
+	// left out are never listed, but any Regexp include keeps every
+	// directory in.
+	Include []*Pattern
+	Exclude []*Pattern
+	// MaxDepth stops listing directories this far below root, entries
+	// directly in root are at depth 1. Zero means no limit, Walk
+	// rejects negative values.
+	MaxDepth int
+
+	Links LinkPolicy
+}
+
+// Walk walks the tree at root with the default settings.
+func Walk(client *goftp.Client, root string, fn fs.WalkDirFunc) error {
+	w := Walker{Client: client}
+	return w.Walk(root, fn)
+}
+
+// dir is a directory waiting to be listed.
+type dir struct {
+	path     string
+	entry    fs.DirEntry
+	rel      string // path relative to the root
+	depth    int
+	included bool   // matched or below an Include
+	real     string // path with followed links resolved
+	up       *dir   // parent directory, nil for the root
+}
+
+type listing struct {
+	dir
+	infos []os.FileInfo
+	links map[string]followed // by name, with LinkFollow
+	err   error
+}
+
+// Walk calls fn for root and everything below it like filepath.WalkDir.
+// Directories are listed concurrently but fn is never called in parallel.
+// Entries within a directory are visited in lexical order and always
+// after their directory, no order is guaranteed between directories.
+func (w *Walker) Walk(root string, fn fs.WalkDirFunc) error {
+	if w.MaxDepth < 0 {
+		return errors.New("ftputil: negative MaxDepth")
+	}
+
+	var entry fs.DirEntry
+	if info, err := w.Client.Stat(root); err == nil {
+		entry = fs.FileInfoToDirEntry(NewEntry(info))
+	} else {
+		// LIST based servers cannot stat directories so assume root
+		// is one, listing it will report any real problem.
+		entry = rootEntry(path.Base(root))
+	}
+
+	err := fn(root, entry, nil)
+	if err != nil || !entry.IsDir() {
+		if err == SkipDir || err == SkipAll {
+			err = nil
+		}
+		return err
+	}
+
+	return w.walk(dir{
+		path:     root,
+		entry:    entry,
+		included: len(w.Include) == 0,
+		real:     path.Clean(root),
+	}, fn)
+}
+
+func (w *Walker) walk(root dir, fn fs.WalkDirFunc) error {
+	workers := w.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+
+	var wg sync.WaitGroup
+	jobs := make(chan dir)
+	results := make(chan listing)
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		close(jobs)
+		wg.Wait()
+	}()
+
+	for n := 0; n < workers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range jobs {
+				l := listing{dir: d}
+				l.infos, l.err = w.Client.ReadDir(d.path)
+				if l.err == nil && w.Links == LinkFollow {
+					l.links = w.followLinks(&l.dir, l.infos)
+				}
+				select {
+				case results <- l:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	// Pending directories are taken from the end to go depth first,
+	// keeping the list short compared to the size of the tree.
+	pending := []dir{root}
+	active := 0
+	var errs WalkError
+loop:
+	for len(pending) != 0 || active != 0 {
+		var (
+			send chan dir
+			next dir
+		)
+		if len(pending) != 0 {
+			send = jobs
+			next = pending[len(pending)-1]
+		}
+
+		select {
+		case send <- next:
+			pending = pending[:len(pending)-1]
+			active++
+		case l := <-results:
+			active--
+			var dirs []dir
+			var err error
+			if l.err != nil {
+				err = w.failed(l.dir, l.err, fn, &errs)
+			} else {
+				dirs, err = w.visit(l, fn, &errs)
+			}
+			if err == SkipAll {
+				break loop
+			} else if err != nil {
+				return err
+			}
+			for n := len(dirs) - 1; n >= 0; n-- {
+				pending = append(pending, dirs[n])
+			}
+		}
+	}
+
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+// failed handles an error listing a directory according to the policy.
+func (w *Walker) failed(d dir, err error, fn fs.WalkDirFunc, errs *WalkError) error {
+	perr := newPathError(d.path, err)
+	switch w.Errors {
+	case ErrorAbort:
+		return perr
+	case ErrorSkip:
+		*errs = append(*errs, perr)
+		return nil
+	}
+	if err := fn(d.path, d.entry, perr); err != SkipDir {
+		return err
+	}
+	return nil
+}
+
+// visit calls fn for a directory's entries, returning the directories
+// to descend into.
+func (w *Walker) visit(l listing, fn fs.WalkDirFunc, errs *WalkError) ([]dir, error) {
+	for n, info := range l.infos {
+		l.infos[n] = NewEntry(info)
+	}
+	sort.Slice(l.infos, func(a, b int) bool {
+		return l.infos[a].Name() < l.infos[b].Name()
+	})
+
+	var dirs []dir
+	for _, info := range l.infos {
+		name := info.Name()
+		if name == "." || name == ".." {
+			continue
+		}
+		d := dir{
+			path:     path.Join(l.path, name),
+			entry:    fs.FileInfoToDirEntry(info),
+			rel:      path.Join(l.rel, name),
+			depth:    l.depth + 1,
+			included: l.included,
+			real:     path.Join(l.real, name),
+			up:       &l.dir,
+		}
+		if d.entry.Type()&fs.ModeSymlink != 0 {
+			if w.Links == LinkSkip {
+				continue
+			}
+			if f, ok := l.links[name]; ok {
+				if f.err != nil {
+					if err := w.failed(d, f.err, fn, errs); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				if f.info != nil {
+					d.entry = fs.FileInfoToDirEntry(f.info)
+					d.real = f.real
+				}
+			}
+		}
+		if !w.filter(&d) {
+			continue
+		}
+		if err := fn(d.path, d.entry, nil); err == SkipDir {
+			if d.entry.IsDir() {
+				continue
+			}
+			// Skip the rest of this directory.
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if d.entry.IsDir() && (w.MaxDepth == 0 || d.depth < w.MaxDepth) {
+			dirs = append(dirs, d)
+		}
+	}
+	return dirs, nil
+}
+
+// rootEntry stands in for a root directory which cannot be stat'ed.
+type rootEntry string
+
+func (r rootEntry) Name() string               { return string(r) }
+func (r rootEntry) IsDir() bool                { return true }
+func (r rootEntry) Type() fs.FileMode          { return fs.ModeDir }
+func (r rootEntry) Info() (fs.FileInfo, error) { return r, nil }
+func (r rootEntry) Size() int64                { return 0 }
+func (r rootEntry) Mode() fs.FileMode          { return fs.ModeDir | 0755 }
+func (r rootEntry) ModTime() time.Time         { return time.Time{} }
+func (r rootEntry) Sys() interface{}           { return nil }
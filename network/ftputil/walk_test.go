@@ -0,0 +1,137 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftputil
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	client, err := NewTestClient(nil)
+	if err != nil {
+		t.Fatal("Test client failed:", err)
+	}
+	defer client.Close()
+
+	var visited, skipped []string
+	root := client.URL().Path
+	err = Walk(client.Client, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		for _, dir := range skipped {
+			if strings.HasPrefix(name, dir+"/") {
+				t.Errorf("walked into skipped directory: %s", name)
+			}
+		}
+		visited = append(visited, name)
+		// Skip the pure-ftpd build tree, if any.
+		if d.IsDir() && strings.HasPrefix(d.Name(), "pure-ftpd-") {
+			skipped = append(skipped, name)
+			return SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal("Walk failed:", err)
+	}
+
+	if len(visited) == 0 || visited[0] != root {
+		t.Fatalf("root %q not visited first: %q", root, visited)
+	}
+	found := false
+	for _, name := range visited {
+		found = found || name == "/ftpd.pem"
+	}
+	if !found {
+		t.Errorf("ftpd.pem missing from walk: %q", visited)
+	}
+}
+
+func TestWalkStop(t *testing.T) {
+	client, err := NewTestClient(nil)
+	if err != nil {
+		t.Fatal("Test client failed:", err)
+	}
+	defer client.Close()
+
+	errStop := errors.New("stop")
+	for _, test := range []struct {
+		at     string
+		ret    error
+		expect error
+		calls  int
+	}{
+		{"/", SkipDir, nil, 1},
+		{"/", SkipAll, nil, 1},
+		{"/", errStop, errStop, 1},
+		{"/ftpd.pem", errStop, errStop, -1},
+		{"/ftpd.pem", SkipAll, nil, -1},
+	} {
+		var calls []string
+		err := Walk(client.Client, "/", func(name string, d fs.DirEntry, err error) error {
+			calls = append(calls, name)
+			if name == test.at {
+				return test.ret
+			}
+			return err
+		})
+		if err != test.expect {
+			t.Errorf("%s returning %v: Walk returned %v, expected %v",
+				test.at, test.ret, err, test.expect)
+		}
+		if calls[len(calls)-1] != test.at {
+			t.Errorf("%s returning %v: walk continued to %q",
+				test.at, test.ret, calls[len(calls)-1])
+		}
+		if test.calls > 0 && len(calls) != test.calls {
+			t.Errorf("%s returning %v: got %d calls, expected %d",
+				test.at, test.ret, len(calls), test.calls)
+		}
+	}
+}
+
+func TestWalkErrors(t *testing.T) {
+	client, err := NewTestClient(nil)
+	if err != nil {
+		t.Fatal("Test client failed:", err)
+	}
+	defer client.Close()
+
+	for _, policy := range []ErrorPolicy{ErrorCallback, ErrorAbort, ErrorSkip} {
+		w := Walker{Client: client.Client, Errors: policy}
+		var called error
+		err := w.Walk("/missing", func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				called = err
+			}
+			return err
+		})
+
+		var perr *PathError
+		if !errors.As(err, &perr) || perr.Path != "/missing" || perr.Code == 0 {
+			t.Errorf("policy %d: got %v, expected /missing with a reply code", policy, err)
+		}
+		if (called != nil) != (policy == ErrorCallback) {
+			t.Errorf("policy %d: callback got error %v", policy, called)
+		}
+		if _, ok := err.(WalkError); ok != (policy == ErrorSkip) {
+			t.Errorf("policy %d: got %T", policy, err)
+		}
+	}
+}
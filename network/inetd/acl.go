@@ -0,0 +1,131 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// rule matches connections by address or unix peer credentials.
+type rule struct {
+	all      bool
+	network  *net.IPNet
+	uid, gid int // -1 if unused
+}
+
+type acl []rule
+
+func parseACL(rules []string) (acl, error) {
+	var a acl
+	for _, s := range rules {
+		r, err := parseRule(s)
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, r)
+	}
+	return a, nil
+}
+
+func parseRule(s string) (rule, error) {
+	r := rule{uid: -1, gid: -1}
+	var id uint64
+	var err error
+	switch {
+	case s == "ALL":
+		r.all = true
+	case strings.HasPrefix(s, "uid:"):
+		id, err = strconv.ParseUint(s[4:], 10, 32)
+		r.uid = int(id)
+	case strings.HasPrefix(s, "gid:"):
+		id, err = strconv.ParseUint(s[4:], 10, 32)
+		r.gid = int(id)
+	case strings.Contains(s, "/"):
+		_, r.network, err = net.ParseCIDR(s)
+	default:
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return r, fmt.Errorf("inetd: invalid access rule %q", s)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		bits := len(ip) * 8
+		r.network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	}
+	if err != nil {
+		return r, fmt.Errorf("inetd: invalid access rule %q: %w", s, err)
+	}
+	return r, nil
+}
+
+// peer describes the other end of a connection for matching rules.
+type peer struct {
+	ip   net.IP       // nil for non-IP networks
+	cred *credentials // nil unless a unix socket
+}
+
+func connPeer(conn net.Conn) peer {
+	p := peer{ip: addrIP(conn.RemoteAddr())}
+	if uc, ok := conn.(*net.UnixConn); ok {
+		// Without credentials uid and gid rules just don't match.
+		p.cred, _ = peerCred(uc)
+	}
+	return p
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	}
+	return nil
+}
+
+func (r rule) match(p peer) bool {
+	switch {
+	case r.all:
+		return true
+	case r.network != nil:
+		return p.ip != nil && r.network.Contains(p.ip)
+	case r.uid >= 0:
+		return p.cred != nil && p.cred.uid == r.uid
+	case r.gid >= 0:
+		return p.cred != nil && p.cred.gid == r.gid
+	}
+	return false
+}
+
+func (a acl) match(p peer) bool {
+	for _, r := range a {
+		if r.match(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// permit checks p against the Allow and Deny rules.
+func (i *Inetd) permit(p peer) error {
+	if i.allow.match(p) || !i.deny.match(p) {
+		return nil
+	}
+	return ErrDenied
+}
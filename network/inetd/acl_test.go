@@ -0,0 +1,147 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestPermit(t *testing.T) {
+	i, err := newInetd(Config{
+		Allow: []string{"192.0.2.1", "2001:db8::/32", "uid:1000"},
+		Deny:  []string{"192.0.2.0/24", "2001::/16", "gid:100"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		peer peer
+		err  error
+	}{
+		{peer{ip: net.ParseIP("192.0.2.1")}, nil},
+		{peer{ip: net.ParseIP("192.0.2.2")}, ErrDenied},
+		{peer{ip: net.ParseIP("198.51.100.1")}, nil},
+		{peer{ip: net.ParseIP("2001:db8::1")}, nil},
+		{peer{ip: net.ParseIP("2001:1::1")}, ErrDenied},
+		{peer{cred: &credentials{uid: 1000, gid: 100}}, nil},
+		{peer{cred: &credentials{uid: 1001, gid: 100}}, ErrDenied},
+		{peer{cred: &credentials{uid: 1001, gid: 1001}}, nil},
+		{peer{}, nil},
+	} {
+		if err := i.permit(tt.peer); err != tt.err {
+			t.Errorf("%+v: got %v, expected %v", tt.peer, err, tt.err)
+		}
+	}
+}
+
+func TestParseACLInvalid(t *testing.T) {
+	for _, s := range []string{"", "all", "192.0.2.300", "10.0.0.0/33", "uid:", "uid:-1", "gid:x"} {
+		if _, err := parseACL([]string{s}); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+	if _, err := ListenConfig(Config{Deny: []string{"bogus"}}, "tcp", "localhost:0", "true"); err == nil {
+		t.Error("ListenConfig accepted an invalid rule")
+	}
+}
+
+func TestACLWaitMode(t *testing.T) {
+	config := Config{Mode: Wait, Deny: []string{"ALL"}}
+	if i, err := ListenConfig(config, "tcp", "localhost:0", "true"); err == nil {
+		i.Close()
+		t.Error("ListenConfig accepted Deny in Wait mode")
+	}
+	config = Config{Mode: Wait, Allow: []string{"127.0.0.1"}}
+	if i, err := ListenPacketConfig(config, "udp", "127.0.0.1:0", "true"); err == nil {
+		i.Close()
+		t.Error("ListenPacketConfig accepted Allow in Wait mode")
+	}
+}
+
+func TestDeny(t *testing.T) {
+	config := Config{Deny: []string{"ALL"}, Banner: "go away\n"}
+	i, err := ListenConfig(config, "tcp", "localhost:0", "echo", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	data, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != config.Banner {
+		t.Errorf("got %q, expected %q", data, config.Banner)
+	}
+}
+
+func TestAllowUID(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials only supported on linux")
+	}
+
+	config := Config{
+		Allow: []string{fmt.Sprintf("uid:%d", os.Geteuid())},
+		Deny:  []string{"ALL"},
+	}
+	sock := filepath.Join(t.TempDir(), "sock")
+	i, err := ListenConfig(config, "unix", sock, "echo", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	data, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello\n" {
+		t.Errorf("got %q, expected %q", data, "hello\n")
+	}
+}
+
+func TestDenyLog(t *testing.T) {
+	var buf bytes.Buffer
+	l := &logObserver{w: &buf}
+	l.Observe(RejectEvent{Err: ErrLimit})
+	l.Observe(RejectEvent{
+		Remote: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234},
+		Err:    ErrDenied,
+	})
+	expect := "inetd: rejected connection from 192.0.2.1:1234: inetd: access denied\n"
+	if buf.String() != expect {
+		t.Errorf("got %q, expected %q", buf.String(), expect)
+	}
+}
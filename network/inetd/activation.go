@@ -0,0 +1,197 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marineam/experiments/network/neterror"
+)
+
+// Mode selects how sockets are passed to children.
+type Mode int
+
+const (
+	// Nowait passes each connection as stdin and stdout, like
+	// a classic inetd nowait service.
+	Nowait Mode = iota
+
+	// Accept passes each connection as fd 3 using the systemd socket
+	// activation protocol, like a systemd socket with Accept=yes.
+	Accept
+
+	// Wait passes the listening socket to a single child as stdin,
+	// stdout and fd 3 using the systemd socket activation protocol, like
+	// a classic inetd wait service or a systemd socket with Accept=no.
+	// The child is started once a connection or datagram is pending and
+	// restarted on the next one after it exits.
+	Wait
+)
+
+// The first file passed via socket activation, see sd_listen_fds(3).
+const listenFdsStart = 3
+
+// Go cannot set LISTEN_PID between fork and exec so let a shell do it.
+const listenPidScript = `LISTEN_PID=$$; export LISTEN_PID; exec "$0" "$@"`
+
+// activationCommand returns a command which will receive file as fd 3.
+func activationCommand(file *os.File, name, program string, arg ...string) *exec.Cmd {
+	cmd := exec.Command("/bin/sh", append([]string{"-c", listenPidScript, program}, arg...)...)
+	cmd.Env = append(withoutListenEnv(os.Environ()),
+		"LISTEN_FDS=1",
+		"LISTEN_FDNAMES="+name)
+	cmd.ExtraFiles = []*os.File{file}
+	return cmd
+}
+
+// ActivationFiles returns the sockets passed to this process using the
+// systemd socket activation protocol, named by LISTEN_FDNAMES, or nil if
+// there are none. The LISTEN_ variables are removed from the environment
+// and the files marked close-on-exec so children don't inherit them. See
+// sd_listen_fds(3).
+func ActivationFiles() ([]*os.File, error) {
+	pid := os.Getenv("LISTEN_PID")
+	fds := os.Getenv("LISTEN_FDS")
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	if pid != strconv.Itoa(os.Getpid()) || fds == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("inetd: invalid LISTEN_FDS %q", fds)
+	}
+
+	files := make([]*os.File, n)
+	for i := range files {
+		fd := listenFdsStart + i
+		closeOnExec(fd)
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		files[i] = os.NewFile(uintptr(fd), name)
+	}
+	return files, nil
+}
+
+// withoutListenEnv filters out any socket activation variables we may
+// have inherited from our own parent.
+func withoutListenEnv(env []string) []string {
+	var filtered []string
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "LISTEN_") {
+			continue
+		}
+		filtered = append(filtered, kv)
+	}
+	return filtered
+}
+
+// waitReadable blocks until the Wait mode socket has a pending
+// connection or datagram.
+func (i *Inetd) waitReadable(s *socket) error {
+	// A listener's own RawConn doesn't support Read so poll a dup.
+	rc, err := s.lfile.SyscallConn()
+	if err != nil {
+		return err
+	}
+	// Go's poller may report stale readiness from connections the
+	// previous child already accepted so double check every wake up.
+	if err = rc.Read(readable); err != nil {
+		// The loop is exiting either way so ensure the socket is
+		// closed too, and if it already was report that instead.
+		if cerr := s.close(); neterror.IsClosed(cerr) {
+			return cerr
+		}
+	}
+	return err
+}
+
+// wait starts a Wait mode child once the socket is readable and
+// blocks until it exits. Like classic inetd's looping detection a child
+// which leaves input pending is restarted after a back off, as is one
+// over Rate. A connection arriving just as a child exits looks the same
+// but only costs the shortest back off.
+func (i *Inetd) wait(s *socket) error {
+	if err := i.waitReadable(s); err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	ok := i.allowRate("", time.Now())
+	i.mu.Unlock()
+	if !ok {
+		return startError{ErrRate}
+	}
+
+	fsock, err := s.file()
+	if err != nil {
+		return err
+	}
+
+	name := i.config.Name
+	if name == "" {
+		name = "unknown"
+	}
+
+	started := time.Now()
+	cmd := activationCommand(fsock, name, i.program, i.args...)
+	cmd.Stdin = fsock
+	cmd.Stdout = fsock
+	err = i.run(cmd, nil)
+	fsock.Close()
+	if err != nil {
+		return startError{err}
+	}
+
+	i.reap(cmd, nil, started)
+	if i.pending(s) {
+		return startError{ErrLooping}
+	}
+	return nil
+}
+
+// pending reports whether the Wait mode socket is still readable.
+func (i *Inetd) pending(s *socket) bool {
+	rc, err := s.lfile.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var ready bool
+	rc.Control(func(fd uintptr) {
+		ready = pending(fd)
+	})
+	return ready
+}
+
+// startError is a Wait mode child which failed to start or must wait to
+// be restarted. The socket is still readable so it is temporary,
+// retried after a back off.
+type startError struct {
+	err error
+}
+
+func (e startError) Error() string   { return e.err.Error() }
+func (e startError) Unwrap() error   { return e.err }
+func (e startError) Temporary() bool { return true }
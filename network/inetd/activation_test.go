@@ -0,0 +1,333 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// helperCommand returns the program and arguments to run this test
+// binary as a child, dispatching to TestHelperProcess.
+func helperCommand(t *testing.T, name string) (string, []string) {
+	t.Setenv("GO_WANT_HELPER_PROCESS", "1")
+	return os.Args[0], []string{"-test.run=TestHelperProcess", "--", name}
+}
+
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	defer os.Exit(0)
+
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "helper: missing command")
+		os.Exit(2)
+	}
+
+	switch args[1] {
+	case "accept-one":
+		// Accept a single connection from a Wait mode listener.
+		l, err := net.FileListener(os.NewFile(listenFdsStart, "listener"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "helper:", err)
+			os.Exit(1)
+		}
+		c, err := l.Accept()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "helper:", err)
+			os.Exit(1)
+		}
+		fmt.Fprintln(c, os.Getenv("LISTEN_FDNAMES"))
+		c.Close()
+	case "read-one":
+		// Answer a single datagram from a Wait mode socket.
+		p, err := net.FilePacketConn(os.NewFile(listenFdsStart, "socket"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "helper:", err)
+			os.Exit(1)
+		}
+		buf := make([]byte, 512)
+		n, addr, err := p.ReadFrom(buf)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "helper:", err)
+			os.Exit(1)
+		}
+		reply := os.Getenv("LISTEN_FDNAMES") + " " + string(buf[:n])
+		if _, err := p.WriteTo([]byte(reply), addr); err != nil {
+			fmt.Fprintln(os.Stderr, "helper:", err)
+			os.Exit(1)
+		}
+	case "inetd-activated":
+		// Serve a single connection with sockets from ActivationFiles.
+		files, err := ActivationFiles()
+		if err != nil || len(files) != 1 || os.Getenv("LISTEN_FDS") != "" {
+			fmt.Fprintln(os.Stderr, "helper: unexpected activation", files, err)
+			os.Exit(1)
+		}
+		exited := make(chan struct{}, 1)
+		config := Config{Observer: ObserverFunc(func(e Event) {
+			if _, ok := e.(ExitEvent); ok {
+				exited <- struct{}{}
+			}
+		})}
+		i, err := ListenFiles(config, files, "sh", "-c",
+			`echo "${LISTEN_FDS:-none} $0"`, files[0].Name())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "helper:", err)
+			os.Exit(1)
+		}
+		<-exited
+		i.Shutdown(context.Background())
+	default:
+		fmt.Fprintln(os.Stderr, "helper: unknown command", args[1])
+		os.Exit(2)
+	}
+}
+
+func TestAcceptMode(t *testing.T) {
+	config := Config{Mode: Accept}
+	script := `echo "$LISTEN_FDS $LISTEN_FDNAMES $((LISTEN_PID == $$))" >&3`
+	i, err := ListenConfig(config, "tcp", "localhost:0", "sh", "-c", script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	data, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := "1 connection 1\n"; string(data) != expect {
+		t.Errorf("got %q, expected %q", data, expect)
+	}
+}
+
+func TestWaitMode(t *testing.T) {
+	program, args := helperCommand(t, "accept-one")
+	config := Config{Mode: Wait, Name: "test"}
+	i, err := ListenConfig(config, "tcp", "localhost:0", program, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	// The child exits after each connection so this also checks that
+	// a new child is started for the next one.
+	for n := 0; n < 2; n++ {
+		c, err := i.Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(c)
+		c.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expect := "test\n"; string(data) != expect {
+			t.Errorf("got %q, expected %q", data, expect)
+		}
+	}
+}
+
+func TestWaitModeStartError(t *testing.T) {
+	observer, events := eventRecorder()
+	config := Config{Mode: Wait, Cgroup: "/nonexistent", Observer: observer}
+	i, err := ListenConfig(config, "tcp", "localhost:0", "true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// The pending connection keeps the socket readable so the start
+	// is retried rather than stopping the loop.
+	for n := 0; n < 2; n++ {
+		if e, ok := nextEvent(t, events).(ErrorEvent); !ok {
+			t.Fatalf("expected ErrorEvent, got %T", e)
+		} else if e.Op != "start" {
+			t.Errorf("unexpected error: %+v", e)
+		}
+	}
+}
+
+func TestWaitModeLooping(t *testing.T) {
+	observer, events := eventRecorder()
+	config := Config{Mode: Wait, Observer: observer}
+	i, err := ListenConfig(config, "tcp", "localhost:0", "true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// The child never accepts so the connection stays pending, without
+	// a back off it would be restarted hundreds of times a second.
+	var starts, looping int
+	timeout := time.After(500 * time.Millisecond)
+	for done := false; !done; {
+		select {
+		case e := <-events:
+			switch e := e.(type) {
+			case StartEvent:
+				starts++
+			case ErrorEvent:
+				if e.Op != "start" || !errors.Is(e.Err, ErrLooping) {
+					t.Errorf("unexpected error: %+v", e)
+				}
+				looping++
+			}
+		case <-timeout:
+			done = true
+		}
+	}
+	if looping == 0 {
+		t.Error("looping child not reported")
+	}
+	if starts > 20 {
+		t.Errorf("child restarted %d times", starts)
+	}
+}
+
+func TestWaitModeRate(t *testing.T) {
+	observer, events := eventRecorder()
+	config := Config{Mode: Wait, Rate: 0.1, Observer: observer}
+	i, err := ListenConfig(config, "tcp", "localhost:0", "true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// The first start takes the only token, restarts for the pending
+	// connection must then wait for the next one.
+	var starts, limited int
+	timeout := time.After(500 * time.Millisecond)
+	for done := false; !done; {
+		select {
+		case e := <-events:
+			switch e := e.(type) {
+			case StartEvent:
+				starts++
+			case ErrorEvent:
+				if errors.Is(e.Err, ErrRate) {
+					limited++
+				} else if !errors.Is(e.Err, ErrLooping) {
+					t.Errorf("unexpected error: %+v", e)
+				}
+			}
+		case <-timeout:
+			done = true
+		}
+	}
+	if starts != 1 || limited == 0 {
+		t.Errorf("got %d starts and %d rate errors", starts, limited)
+	}
+}
+
+func TestWaitModeConfig(t *testing.T) {
+	for _, config := range []Config{
+		{Mode: Wait, Instances: 1},
+		{Mode: Wait, PerSource: 1},
+		{Mode: Wait, Queue: true},
+		{Mode: Wait, PerSourceRate: 1},
+	} {
+		if i, err := ListenConfig(config, "tcp", "localhost:0", "true"); err == nil {
+			i.Close()
+			t.Errorf("%+v accepted", config)
+		}
+		if i, err := ListenPacketConfig(config, "udp", "localhost:0", "true"); err == nil {
+			i.Close()
+			t.Errorf("%+v accepted for udp", config)
+		}
+	}
+}
+
+func TestActivationFiles(t *testing.T) {
+	program, args := helperCommand(t, "inetd-activated")
+	observer, events := eventRecorder()
+	config := Config{Mode: Wait, Name: "test", Observer: observer}
+	i, err := ListenConfig(config, "tcp", "localhost:0", program, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	data, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := "none test\n"; string(data) != expect {
+		t.Errorf("got %q, expected %q", data, expect)
+	}
+
+	for {
+		if e, ok := nextEvent(t, events).(ExitEvent); ok {
+			if e.Err != nil {
+				t.Errorf("helper failed: %v", e.Err)
+			}
+			break
+		}
+	}
+}
+
+func TestActivationFilesNone(t *testing.T) {
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	files, err := ActivationFiles()
+	if err != nil || files != nil {
+		t.Errorf("got %v, %v for another process's sockets", files, err)
+	}
+	if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+		t.Error("LISTEN_FDS not removed from the environment")
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"crypto/tls"
+	"net"
+	"strconv"
+)
+
+// connEnv returns environment variables describing conn following the
+// UCSPI conventions used by tcpserver and ucspi-unix. Connections
+// wrapped by Record or Faults are described by the one they wrap.
+func connEnv(conn net.Conn) []string {
+	env := addrEnv(conn.LocalAddr(), conn.RemoteAddr())
+	for {
+		switch c := conn.(type) {
+		case *net.UnixConn:
+			if cred, err := peerCred(c); err == nil {
+				env = append(env,
+					"UNIXREMOTEPID="+strconv.Itoa(cred.pid),
+					"UNIXREMOTEEUID="+strconv.Itoa(cred.uid),
+					"UNIXREMOTEEGID="+strconv.Itoa(cred.gid))
+			}
+			return env
+		case *tls.Conn:
+			return append(env, tlsEnv(c.ConnectionState())...)
+		case interface{ Unwrap() net.Conn }:
+			conn = c.Unwrap()
+		default:
+			return env
+		}
+	}
+}
+
+// addrEnv describes a connection or datagram's addresses. UDP isn't
+// part of UCSPI so it just follows the same pattern as TCP.
+func addrEnv(local, remote net.Addr) []string {
+	switch local := local.(type) {
+	case *net.TCPAddr:
+		return ipEnv("TCP", local, remote)
+	case *net.UDPAddr:
+		return ipEnv("UDP", local, remote)
+	case *net.UnixAddr:
+		return []string{
+			"PROTO=UNIX",
+			"UNIXLOCALPATH=" + local.Name,
+		}
+	default:
+		return nil
+	}
+}
+
+func ipEnv(proto string, local, remote net.Addr) []string {
+	env := []string{"PROTO=" + proto}
+	if host, port, err := net.SplitHostPort(local.String()); err == nil {
+		env = append(env,
+			proto+"LOCALIP="+host,
+			proto+"LOCALPORT="+port)
+	}
+	if remote == nil {
+		return env
+	}
+	if host, port, err := net.SplitHostPort(remote.String()); err == nil {
+		env = append(env,
+			proto+"REMOTEIP="+host,
+			proto+"REMOTEPORT="+port)
+	}
+	return env
+}
@@ -0,0 +1,132 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestTCPEnv(t *testing.T) {
+	i, err := Listen("tcp", "localhost:0", "sh", "-c",
+		`echo "$PROTO $TCPLOCALIP:$TCPLOCALPORT $TCPREMOTEIP:$TCPREMOTEPORT"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	data, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := fmt.Sprintf("TCP %s %s\n", c.RemoteAddr(), c.LocalAddr())
+	if string(data) != expect {
+		t.Errorf("got %q, expected %q", data, expect)
+	}
+}
+
+func TestUnixEnv(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials only supported on linux")
+	}
+
+	sock := filepath.Join(t.TempDir(), "sock")
+	i, err := Listen("unix", sock, "sh", "-c",
+		`echo "$PROTO $UNIXLOCALPATH $UNIXREMOTEPID $UNIXREMOTEEUID $UNIXREMOTEEGID"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	data, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := fmt.Sprintf("UNIX %s %d %d %d\n",
+		sock, os.Getpid(), os.Geteuid(), os.Getegid())
+	if string(data) != expect {
+		t.Errorf("got %q, expected %q", data, expect)
+	}
+}
+
+func TestRecordTLSEnv(t *testing.T) {
+	config := Config{
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{testCert(t, "localhost")},
+		},
+		Record: true,
+	}
+	i, err := ListenConfig(config, "tcp", "localhost:0", "sh", "-c", `echo "$SSL_PROTOCOL"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	c := dialTLS(t, i, &tls.Config{InsecureSkipVerify: true})
+	defer c.Close()
+
+	data, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := "TLSv1.3\n"; string(data) != expect {
+		t.Errorf("got %q, expected %q", data, expect)
+	}
+}
+
+func TestFaultsUnixEnv(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials only supported on linux")
+	}
+
+	sock := filepath.Join(t.TempDir(), "sock")
+	config := Config{Faults: &Faults{DropAfter: 1 << 20}}
+	i, err := ListenConfig(config, "unix", sock, "sh", "-c", `echo "$UNIXREMOTEPID"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	data, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := fmt.Sprintf("%d\n", os.Getpid()); string(data) != expect {
+		t.Errorf("got %q, expected %q", data, expect)
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"time"
+)
+
+// An Observer receives events from an Inetd. Observe may be called
+// concurrently from multiple goroutines and should not block.
+type Observer interface {
+	Observe(e Event)
+}
+
+// ObserverFunc adapts an ordinary function to the Observer interface.
+type ObserverFunc func(e Event)
+
+func (f ObserverFunc) Observe(e Event) {
+	f(e)
+}
+
+// MultiObserver returns an Observer which passes each event to all of
+// the given observers in order.
+func MultiObserver(observers ...Observer) Observer {
+	return ObserverFunc(func(e Event) {
+		for _, o := range observers {
+			o.Observe(e)
+		}
+	})
+}
+
+// Event is one of AcceptEvent, RejectEvent, StartEvent, ExitEvent or
+// ErrorEvent.
+type Event interface {
+	String() string
+	event()
+}
+
+// AcceptEvent is sent for each new connection.
+type AcceptEvent struct {
+	Local, Remote net.Addr
+}
+
+// RejectEvent is sent when a connection is closed without starting a
+// child. Err is or wraps one of the Err variables exported by this package.
+type RejectEvent struct {
+	Remote net.Addr
+	Err    error
+}
+
+// StartEvent is sent when a child starts. Remote is nil in Wait mode
+// and Program and Pid are empty for in-process handlers.
+type StartEvent struct {
+	Program string
+	Pid     int
+	Remote  net.Addr
+}
+
+// ExitEvent is sent when a child exits. Err is the result of
+// exec.Cmd.Wait and State includes the child's resource usage.
+// Only Remote and Duration are set for in-process handlers.
+type ExitEvent struct {
+	Program  string
+	Pid      int
+	Remote   net.Addr
+	Err      error
+	State    *os.ProcessState
+	Duration time.Duration
+}
+
+// ErrorEvent is sent when accepting a connection, completing a TLS
+// handshake or starting a child fails, or a reply to a datagram cannot
+// be sent. Op is one of "accept", "handshake", "start" or "reply".
+type ErrorEvent struct {
+	Op  string
+	Err error
+}
+
+func (AcceptEvent) event() {}
+func (RejectEvent) event() {}
+func (StartEvent) event()  {}
+func (ExitEvent) event()   {}
+func (ErrorEvent) event()  {}
+
+func (e AcceptEvent) String() string {
+	return fmt.Sprintf("accepted connection from %s", e.Remote)
+}
+
+func (e RejectEvent) String() string {
+	return fmt.Sprintf("rejected connection from %s: %s", e.Remote, e.Err)
+}
+
+func (e StartEvent) String() string {
+	if e.Pid == 0 {
+		return fmt.Sprintf("started handler for %s", e.Remote)
+	}
+	return fmt.Sprintf("started %s [%d]", e.Program, e.Pid)
+}
+
+func (e ExitEvent) String() string {
+	if e.Pid == 0 {
+		return fmt.Sprintf("handler for %s finished after %s", e.Remote, e.Duration)
+	}
+	if e.Err != nil {
+		return fmt.Sprintf("%s [%d] exited with %s", e.Program, e.Pid, e.Err)
+	}
+	return fmt.Sprintf("%s [%d] exited after %s", e.Program, e.Pid, e.Duration)
+}
+
+func (e ErrorEvent) String() string {
+	return fmt.Sprintf("%s failed: %s", e.Op, e.Err)
+}
+
+// logObserver writes errors, failed children and connections refused
+// by Deny, quiet otherwise.
+type logObserver struct {
+	w io.Writer
+}
+
+func (l *logObserver) Observe(e Event) {
+	switch e := e.(type) {
+	case ErrorEvent:
+	case RejectEvent:
+		if !errors.Is(e.Err, ErrDenied) {
+			return
+		}
+	case ExitEvent:
+		if e.Err == nil {
+			return
+		}
+	default:
+		return
+	}
+	fmt.Fprintf(l.w, "inetd: %s\n", e)
+}
+
+// SlogObserver returns an Observer which logs all events to logger.
+func SlogObserver(logger *slog.Logger) Observer {
+	return ObserverFunc(func(e Event) {
+		level := slog.LevelInfo
+		var attrs []slog.Attr
+		switch e := e.(type) {
+		case AcceptEvent:
+			level = slog.LevelDebug
+			attrs = append(attrs, addrAttr("local", e.Local), addrAttr("remote", e.Remote))
+		case RejectEvent:
+			level = slog.LevelWarn
+			attrs = append(attrs, addrAttr("remote", e.Remote), slog.Any("error", e.Err))
+		case StartEvent:
+			attrs = append(attrs, slog.String("program", e.Program),
+				slog.Int("pid", e.Pid), addrAttr("remote", e.Remote))
+		case ExitEvent:
+			attrs = append(attrs, slog.String("program", e.Program),
+				slog.Int("pid", e.Pid), addrAttr("remote", e.Remote),
+				slog.Duration("duration", e.Duration))
+			if e.State != nil {
+				attrs = append(attrs, slog.Int("status", e.State.ExitCode()),
+					slog.Duration("user", e.State.UserTime()),
+					slog.Duration("system", e.State.SystemTime()))
+			}
+			if e.Err != nil {
+				level = slog.LevelWarn
+				attrs = append(attrs, slog.Any("error", e.Err))
+			}
+		case ErrorEvent:
+			level = slog.LevelError
+			attrs = append(attrs, slog.String("op", e.Op), slog.Any("error", e.Err))
+		}
+		logger.LogAttrs(context.Background(), level, "inetd: "+e.String(), attrs...)
+	})
+}
+
+func addrAttr(key string, addr net.Addr) slog.Attr {
+	if addr == nil {
+		return slog.String(key, "")
+	}
+	return slog.String(key, addr.String())
+}
@@ -0,0 +1,147 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log/slog"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eventRecorder returns an Observer and a channel of its events.
+func eventRecorder() (Observer, <-chan Event) {
+	events := make(chan Event, 16)
+	return ObserverFunc(func(e Event) {
+		events <- e
+	}), events
+}
+
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for event")
+		return nil
+	}
+}
+
+func TestObserver(t *testing.T) {
+	observer, events := eventRecorder()
+	config := Config{Observer: observer}
+	i, err := ListenConfig(config, "tcp", "localhost:0", "true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if e, ok := nextEvent(t, events).(AcceptEvent); !ok {
+		t.Fatalf("expected AcceptEvent, got %T", e)
+	} else if e.Remote.String() != c.LocalAddr().String() {
+		t.Errorf("accepted %s, expected %s", e.Remote, c.LocalAddr())
+	}
+
+	start, ok := nextEvent(t, events).(StartEvent)
+	if !ok {
+		t.Fatalf("expected StartEvent, got %T", start)
+	}
+	if start.Program != "true" || start.Pid == 0 {
+		t.Errorf("unexpected start: %+v", start)
+	}
+
+	exit, ok := nextEvent(t, events).(ExitEvent)
+	if !ok {
+		t.Fatalf("expected ExitEvent, got %T", exit)
+	}
+	if exit.Pid != start.Pid || exit.Err != nil || !exit.State.Success() {
+		t.Errorf("unexpected exit: %+v", exit)
+	}
+}
+
+func TestObserverError(t *testing.T) {
+	observer, events := eventRecorder()
+	config := Config{Observer: observer}
+	i, err := ListenConfig(config, "tcp", "localhost:0", "/nonexistent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	nextEvent(t, events) // AcceptEvent
+	if e, ok := nextEvent(t, events).(ErrorEvent); !ok {
+		t.Fatalf("expected ErrorEvent, got %T", e)
+	} else if e.Op != "start" {
+		t.Errorf("unexpected error: %+v", e)
+	}
+
+	// The failure must not stop the accept loop.
+	c2, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	if _, ok := nextEvent(t, events).(AcceptEvent); !ok {
+		t.Error("second connection not accepted")
+	}
+}
+
+func TestSlogObserver(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	SlogObserver(logger).Observe(RejectEvent{Err: ErrLimit})
+	if !strings.Contains(buf.String(), "level=WARN") ||
+		!strings.Contains(buf.String(), ErrLimit.Error()) {
+		t.Errorf("unexpected log output: %q", buf.String())
+	}
+}
+
+func TestLogObserver(t *testing.T) {
+	var buf bytes.Buffer
+	config := Config{Observer: &logObserver{w: &buf}}
+	i, err := ListenConfig(config, "tcp", "localhost:0", "false")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(c)
+	c.Close()
+
+	if err := i.Shutdown(testContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^inetd: false \[[0-9]+\] exited with exit status 1\n$`).MatchString(buf.String()) {
+		t.Errorf("unexpected log output: %q", buf.String())
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Faults injects network problems into connections for testing how
+// clients cope with flaky servers. Connections are relayed through this
+// process. Counts are bytes relayed in either direction, zero disables
+// a fault.
+type Faults struct {
+	// Latency delays each read from and write to the client.
+	Latency time.Duration
+
+	// Bandwidth limits each direction to this many bytes per second.
+	Bandwidth int
+
+	// DropAfter closes the connection after this many bytes.
+	DropAfter int64
+
+	// ResetAfter resets the connection after this many bytes. Only
+	// TCP connections can be reset, others are closed instead.
+	ResetAfter int64
+
+	// StallAfter and StallFor stop reading from the client for StallFor
+	// once StallAfter bytes have been received. A negative StallFor
+	// stalls until the connection is closed.
+	StallAfter int64
+	StallFor   time.Duration
+}
+
+// faultConn applies Faults to a client connection.
+type faultConn struct {
+	net.Conn
+	f    *Faults
+	done chan struct{} // closed by Close to interrupt delays
+	once sync.Once
+
+	mu       sync.Mutex
+	count    int64 // bytes in either direction
+	received int64
+	stalled  bool
+	failed   bool
+}
+
+func newFaultConn(conn net.Conn, f *Faults) *faultConn {
+	return &faultConn{Conn: conn, f: f, done: make(chan struct{})}
+}
+
+// limit returns the byte count at which the connection fails and if
+// it should be reset rather than closed.
+func (c *faultConn) limit() (int64, bool) {
+	drop, reset := c.f.DropAfter, c.f.ResetAfter
+	switch {
+	case reset > 0 && (drop <= 0 || reset <= drop):
+		return reset, true
+	case drop > 0:
+		return drop, false
+	}
+	return 0, false
+}
+
+// take accounts for n bytes, returning how many of them may be
+// delivered before the connection fails.
+func (c *faultConn) take(n int, read bool) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	limit, _ := c.limit()
+	fail := false
+	if limit > 0 && c.count+int64(n) >= limit {
+		n = int(limit - c.count)
+		fail = true
+	}
+	c.count += int64(n)
+	if read {
+		c.received += int64(n)
+	}
+	return n, fail
+}
+
+// fail closes or resets the connection once its limit is reached.
+func (c *faultConn) fail() {
+	c.mu.Lock()
+	failed := c.failed
+	c.failed = true
+	c.mu.Unlock()
+	if failed {
+		return
+	}
+
+	if _, reset := c.limit(); reset {
+		if tc, ok := unwrapConn(c.Conn).(*net.TCPConn); ok {
+			tc.SetLinger(0)
+		}
+	}
+	c.Close()
+}
+
+// delay sleeps for d or until the connection is closed.
+func (c *faultConn) delay(d time.Duration) error {
+	if d == 0 {
+		return nil
+	}
+	var timeout <-chan time.Time
+	if d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case <-timeout:
+		return nil
+	case <-c.done:
+		return net.ErrClosed
+	}
+}
+
+// stall blocks reads once StallAfter bytes have been received.
+func (c *faultConn) stall() error {
+	if c.f.StallFor == 0 {
+		return nil
+	}
+	c.mu.Lock()
+	stall := !c.stalled && c.received >= c.f.StallAfter
+	if stall {
+		c.stalled = true
+	}
+	c.mu.Unlock()
+	if !stall {
+		return nil
+	}
+	return c.delay(c.f.StallFor)
+}
+
+// chunk limits p to a tenth of a second's worth of Bandwidth.
+func (c *faultConn) chunk(p []byte) []byte {
+	if c.f.Bandwidth > 0 {
+		size := c.f.Bandwidth / 10
+		if size < 1 {
+			size = 1
+		}
+		if len(p) > size {
+			p = p[:size]
+		}
+	}
+	return p
+}
+
+// throttle waits long enough after transferring n bytes to stay
+// within Bandwidth.
+func (c *faultConn) throttle(n int) error {
+	if c.f.Bandwidth <= 0 || n == 0 {
+		return nil
+	}
+	return c.delay(time.Duration(n) * time.Second / time.Duration(c.f.Bandwidth))
+}
+
+func (c *faultConn) Read(p []byte) (int, error) {
+	if err := c.stall(); err != nil {
+		return 0, err
+	}
+
+	n, err := c.Conn.Read(c.chunk(p))
+	n, fail := c.take(n, true)
+	if fail {
+		c.fail()
+		if err == nil && n == 0 {
+			err = net.ErrClosed
+		}
+	}
+	if n > 0 {
+		// Delay delivering data already received, not the reading.
+		if derr := c.delay(c.f.Latency); derr != nil {
+			return 0, derr
+		}
+		if terr := c.throttle(n); terr != nil && err == nil {
+			err = terr
+		}
+	}
+	return n, err
+}
+
+func (c *faultConn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+func (c *faultConn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if err := c.delay(c.f.Latency); err != nil {
+			return written, err
+		}
+
+		b := c.chunk(p)
+		allowed, fail := c.take(len(b), false)
+		n, err := c.Conn.Write(b[:allowed])
+		written += n
+		p = p[n:]
+		if fail {
+			c.fail()
+			return written, net.ErrClosed
+		}
+		if err != nil {
+			return written, err
+		}
+		if err := c.throttle(n); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func (c *faultConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+func (c *faultConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return c.Conn.Close()
+}
+
+// unwrapConn returns the socket underneath a proxyConn.
+func unwrapConn(conn net.Conn) net.Conn {
+	if pc, ok := conn.(*proxyConn); ok {
+		return pc.Conn
+	}
+	return conn
+}
@@ -0,0 +1,100 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// faultRead connects to a service with faults and reads everything.
+func faultRead(t *testing.T, f *Faults, script, input string) (string, time.Duration, error) {
+	i, err := ListenConfig(Config{Faults: f}, "tcp", "localhost:0", "sh", "-c", script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { i.Close() })
+
+	start := time.Now()
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if input != "" {
+		if _, err := c.Write([]byte(input)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := ioutil.ReadAll(c)
+	return string(data), time.Since(start), err
+}
+
+func TestFaultDrop(t *testing.T) {
+	data, _, err := faultRead(t, &Faults{DropAfter: 5}, "echo 0123456789", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data != "01234" {
+		t.Errorf("got %q, expected %q", data, "01234")
+	}
+}
+
+func TestFaultReset(t *testing.T) {
+	// Wait for the client so the reset can't race with connecting,
+	// the 3 bytes sent count towards the limit too.
+	data, _, err := faultRead(t, &Faults{ResetAfter: 8}, "read x; echo 0123456789", "go\n")
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("got %v, expected %v", err, syscall.ECONNRESET)
+	}
+	if data != "01234" {
+		t.Errorf("got %q, expected %q", data, "01234")
+	}
+}
+
+func TestFaultDelay(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		f      *Faults
+		script string
+		input  string
+		expect string
+		min    time.Duration
+	}{
+		{"latency", &Faults{Latency: 100 * time.Millisecond},
+			"echo hello", "", "hello\n", 100 * time.Millisecond},
+		{"bandwidth", &Faults{Bandwidth: 1000},
+			"printf %0300d 0", "", strings.Repeat("0", 300), 250 * time.Millisecond},
+		{"stall", &Faults{StallFor: 200 * time.Millisecond},
+			"read line; echo $line", "abc\n", "abc\n", 200 * time.Millisecond},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			data, elapsed, err := faultRead(t, tt.f, tt.script, tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data != tt.expect {
+				t.Errorf("got %q, expected %q", data, tt.expect)
+			}
+			if elapsed < tt.min {
+				t.Errorf("took %s, expected at least %s", elapsed, tt.min)
+			}
+		})
+	}
+}
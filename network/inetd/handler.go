@@ -0,0 +1,111 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// A Handler serves connections in-process instead of starting a child.
+//
+// The context is canceled when Shutdown begins, and if the handler does
+// not return before Shutdown's own context expires the connection is
+// closed out from under it.
+type Handler interface {
+	ServeConn(ctx context.Context, conn net.Conn)
+}
+
+// HandlerFunc adapts an ordinary function to the Handler interface.
+type HandlerFunc func(ctx context.Context, conn net.Conn)
+
+func (f HandlerFunc) ServeConn(ctx context.Context, conn net.Conn) {
+	f(ctx, conn)
+}
+
+// ListenFunc is like ListenHandler with a function and default settings.
+func ListenFunc(network, address string, handler func(context.Context, net.Conn)) (*Inetd, error) {
+	return ListenHandler(Config{}, network, address, HandlerFunc(handler))
+}
+
+// ListenHandler is like ListenConfig but runs handler on a new goroutine
+// for each connection. Only Nowait mode is supported and settings that
+// only make sense for processes such as Env are ignored.
+func ListenHandler(config Config, network, address string, handler Handler) (*Inetd, error) {
+	if config.Mode != Nowait {
+		return nil, errors.New("inetd: handlers only support Nowait mode")
+	}
+
+	i, err := newInetd(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := i.listen([]Address{{network, address}}, false, nil); err != nil {
+		return nil, err
+	}
+
+	i.handler = handler
+	if err := i.loop(); err != nil {
+		return nil, err
+	}
+
+	return i, nil
+}
+
+// handlerChild maps the signals sent by Shutdown onto a handler.
+type handlerChild struct {
+	cancel context.CancelFunc
+	conn   net.Conn
+}
+
+func (h *handlerChild) Signal(sig os.Signal) error {
+	h.cancel()
+	if sig == syscall.SIGKILL {
+		return h.conn.Close()
+	}
+	return nil
+}
+
+func (i *Inetd) handle(conn net.Conn) {
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := &handlerChild{cancel: cancel, conn: conn}
+	i.track(h, nil)
+	i.observer.Observe(StartEvent{Remote: conn.RemoteAddr()})
+
+	started := time.Now()
+	// Deferred so a handler which panics or exits its goroutine is
+	// still removed from the running children.
+	defer func() {
+		var err error
+		if i.untrack(h) {
+			err = ErrTimeout
+		}
+		i.observer.Observe(ExitEvent{
+			Remote:   conn.RemoteAddr(),
+			Err:      err,
+			Duration: time.Since(started),
+		})
+	}()
+
+	i.handler.ServeConn(ctx, conn)
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestListenFunc(t *testing.T) {
+	i, err := ListenFunc("tcp", "localhost:0", func(ctx context.Context, conn net.Conn) {
+		io.Copy(conn, conn)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := io.WriteString(c, "test\n"); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(c).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "test\n" {
+		t.Errorf("got %q, expected %q", line, "test\n")
+	}
+}
+
+func TestHandlerShutdown(t *testing.T) {
+	canceled := make(chan struct{})
+	i, err := ListenFunc("tcp", "localhost:0", func(ctx context.Context, conn net.Conn) {
+		io.WriteString(conn, "ready\n")
+		<-ctx.Done()
+		close(canceled)
+		// Keep going until Shutdown gives up and closes conn.
+		io.Copy(io.Discard, conn)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := dialReady(t, i)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := i.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown returned %v, expected %v", err, context.DeadlineExceeded)
+	}
+
+	select {
+	case <-canceled:
+	default:
+		t.Error("handler context was not canceled")
+	}
+}
+
+func TestHandlerGoexit(t *testing.T) {
+	i, err := ListenFunc("tcp", "localhost:0", func(ctx context.Context, conn net.Conn) {
+		runtime.Goexit()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	io.Copy(io.Discard, c)
+
+	if err := i.Drain(testContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	if n := i.Active(); n != 0 {
+		t.Errorf("%d active handlers after Drain", n)
+	}
+}
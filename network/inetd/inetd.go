@@ -16,94 +16,692 @@
 package inetd
 
 import (
-	"fmt"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
 	"net"
 	"os"
 	"os/exec"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/marineam/experiments/network/neterror"
 )
 
+// Config holds optional settings for ListenConfig and ListenHandler.
+type Config struct {
+	// Instances limits the number of concurrently running children,
+	// like xinetd's instances option. Zero means no limit. Not
+	// supported in Wait mode, which runs one child per socket.
+	Instances int
+
+	// PerSource limits the number of concurrently running children
+	// for a single remote address, like xinetd's per_source option.
+	// Zero means no limit. Not supported in Wait mode.
+	PerSource int
+
+	// Queue holds connections over the limits until a slot frees up
+	// instead of rejecting them. QueueLimit bounds how many are held at
+	// once, further ones are rejected with ErrLimit. Zero means 128.
+	// Close rejects any still held with ErrShutdown. Not supported in
+	// Wait mode.
+	Queue      bool
+	QueueLimit int
+
+	// Banner is written to rejected connections before closing them.
+	Banner string
+
+	// Mode selects how sockets are passed to children.
+	Mode Mode
+
+	// Name is passed to Wait mode children in LISTEN_FDNAMES.
+	Name string
+
+	// Env is added to the environment inherited by children.
+	Env []string
+
+	// Dir is the working directory for children, the current
+	// directory is used if empty.
+	Dir string
+
+	// Stderr receives children's stderr, defaults to os.Stderr.
+	Stderr io.Writer
+
+	// SysProcAttr is copied to each child's exec.Cmd, allowing
+	// options such as Credential, Setsid and Pdeathsig.
+	SysProcAttr *syscall.SysProcAttr
+
+	// Observer receives events such as accepted connections and child
+	// exits. By default errors and failed children are logged to stderr.
+	Observer Observer
+
+	// TLSConfig enables TLS termination. Children receive plaintext
+	// over a socketpair and details of the TLS session in SSL_*
+	// environment variables. Not supported in Wait mode.
+	TLSConfig *tls.Config
+
+	// ProxyProtocol requires a PROXY protocol v1 or v2 header at the
+	// start of each connection, as sent by load balancers such as
+	// HAProxy. The addresses in the header replace the connection's
+	// own for limits and the environment. Connections without a valid
+	// header are rejected. Not supported in Wait mode.
+	ProxyProtocol bool
+
+	// RlimitCPU, RlimitAS and RlimitNofile set resource limits for
+	// children, like xinetd's rlimit_cpu, rlimit_as and rlimit_files.
+	// Both the soft and hard limits are set. Zero leaves the limit
+	// inherited from this process. Linux only.
+	RlimitCPU    time.Duration
+	RlimitAS     uint64
+	RlimitNofile uint64
+
+	// Timeout kills children still running after this long, reported
+	// as ErrTimeout in ExitEvent. Zero means no limit.
+	Timeout time.Duration
+
+	// Cgroup is a cgroup v2 directory, typically a delegated subtree,
+	// in which each child is placed in a new cgroup of its own. The
+	// child's cgroup is removed along with any processes left in it
+	// when the child exits. CgroupMemoryMax and CgroupPidsMax set its
+	// memory.max and pids.max if non-zero. Linux only.
+	Cgroup          string
+	CgroupMemoryMax int64
+	CgroupPidsMax   int64
+
+	// Rate limits new connections per second with a token bucket
+	// holding up to Burst, like xinetd's cps option. PerSourceRate and
+	// PerSourceBurst do the same for each remote address. Connections
+	// over the rate are rejected with ErrRate. Zero means no limit, a
+	// burst of zero is treated as one. In Wait mode Rate limits how
+	// often the child is started instead, starts over the rate are
+	// delayed, and PerSourceRate is not supported.
+	Rate           float64
+	Burst          int
+	PerSourceRate  float64
+	PerSourceBurst int
+
+	// Allow and Deny are access rules checked before starting a child,
+	// like tcpwrappers' hosts.allow and hosts.deny: a connection matching
+	// Allow is accepted, otherwise one matching Deny is rejected with
+	// ErrDenied, otherwise it is accepted. Rules are an IP address or
+	// CIDR block, "uid:N" or "gid:N" to match the peer credentials of
+	// unix socket connections, or "ALL". Use Deny: []string{"ALL"} to
+	// only accept connections matching Allow. Not supported in Wait
+	// mode, the child accepts connections itself.
+	Allow []string
+	Deny  []string
+
+	// Record relays connections through this process instead of passing
+	// the socket to children, recording the data in each direction and
+	// children's stderr in a Transcript. Meant for debugging tests, all
+	// transcripts are kept for the life of the Inetd. Not supported in
+	// Wait mode.
+	Record bool
+
+	// Faults injects network problems such as latency and dropped
+	// connections for testing clients. Not supported in Wait mode.
+	Faults *Faults
+}
+
 type Inetd struct {
-	listener net.Listener
+	sockets  []*socket
 	program  string
 	args     []string
+	handler  Handler
+	config   Config
+	allow    acl
+	deny     acl
+	observer Observer
+	wg       sync.WaitGroup // accept loops and connections
+
+	mu       sync.Mutex
+	cond     *sync.Cond // broadcast when a slot frees, on Close or Shutdown
+	active   int
+	queued   int
+	closed   bool
+	sources  map[string]int
+	rate     bucket
+	buckets  map[string]*bucket // per source rate limits
+	pruned   time.Time          // last time full buckets were removed
+	rejects  atomic.Uint64
+	children map[child]*tracked
+	stop     os.Signal // last signal sent by Shutdown, nil until then
+
+	// ctx is canceled by Shutdown.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	transcripts []*Transcript // guarded by mu
+}
+
+// child is a running process or in-process handler.
+type child interface {
+	Signal(sig os.Signal) error
+}
+
+// tracked holds per child state released by untrack.
+type tracked struct {
+	timer    *time.Timer
+	timedOut bool
+	cgroup   *cgroup
 }
 
 func Listen(network, address, program string, arg ...string) (*Inetd, error) {
-	listener, err := net.Listen(network, address)
+	return ListenConfig(Config{}, network, address, program, arg...)
+}
+
+func ListenConfig(config Config, network, address, program string, arg ...string) (*Inetd, error) {
+	return listenAll(config, []Address{{network, address}}, nil, program, arg)
+}
+
+// ListenAll is like ListenConfig but listens on several addresses at
+// once, sharing limits between them. Unlike ListenConfig host names are
+// resolved and every address is used. If the port is zero the same port
+// is picked for all of a host's addresses, unless it is already taken on
+// one of them which then gets a port of its own.
+func ListenAll(config Config, addrs []Address, program string, arg ...string) (*Inetd, error) {
+	return listenAll(config, addrs, net.DefaultResolver.LookupNetIP, program, arg)
+}
+
+func listenAll(config Config, addrs []Address, lookup lookupFunc, program string, arg []string) (*Inetd, error) {
+	if err := config.checkStream(); err != nil {
+		return nil, err
+	}
+
+	i, err := newInetd(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := i.listen(addrs, false, lookup); err != nil {
+		return nil, err
+	}
+
+	i.program = program
+	i.args = arg
+	if err := i.loop(); err != nil {
+		return nil, err
+	}
+
+	return i, nil
+}
+
+// checkStream rejects settings which stream sockets cannot support.
+func (c *Config) checkStream() error {
+	if c.Mode != Wait {
+		return nil
+	}
+	switch {
+	case c.TLSConfig != nil:
+		return errors.New("inetd: TLS is not supported in Wait mode")
+	case c.ProxyProtocol:
+		return errors.New("inetd: PROXY protocol is not supported in Wait mode")
+	case c.Record:
+		return errors.New("inetd: Record is not supported in Wait mode")
+	case c.Faults != nil:
+		return errors.New("inetd: Faults are not supported in Wait mode")
+	}
+	return c.checkWait()
+}
+
+// checkWait rejects settings which need inetd to see each connection,
+// which a Wait mode child accepts itself.
+func (c *Config) checkWait() error {
+	if c.Mode != Wait {
+		return nil
+	}
+	switch {
+	case len(c.Allow) != 0 || len(c.Deny) != 0:
+		return errors.New("inetd: Allow and Deny are not supported in Wait mode")
+	case c.Instances != 0 || c.PerSource != 0 || c.Queue:
+		return errors.New("inetd: Instances, PerSource and Queue are not supported in Wait mode")
+	case c.PerSourceRate != 0:
+		return errors.New("inetd: PerSourceRate is not supported in Wait mode")
+	}
+	return nil
+}
+
+// newInetd prepares an Inetd for config, the caller opens the sockets.
+func newInetd(config Config) (*Inetd, error) {
+	allow, err := parseACL(config.Allow)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := parseACL(config.Deny)
 	if err != nil {
 		return nil, err
 	}
 
 	i := &Inetd{
-		listener: listener,
-		program:  program,
-		args:     arg,
+		config:   config,
+		allow:    allow,
+		deny:     deny,
+		observer: config.Observer,
+		sources:  make(map[string]int),
+		buckets:  make(map[string]*bucket),
+		children: make(map[child]*tracked),
+	}
+	if i.observer == nil {
+		i.observer = &logObserver{w: os.Stderr}
 	}
+	i.cond = sync.NewCond(&i.mu)
+	i.ctx, i.cancel = context.WithCancel(context.Background())
+	return i, nil
+}
+
+// Delays between retrying temporary accept errors.
+const (
+	minBackoff = 5 * time.Millisecond
+	maxBackoff = time.Second
+)
+
+// temporary reports if err, such as running out of file descriptors,
+// may go away if retried.
+func temporary(err error) bool {
+	var ne interface{ Temporary() bool }
+	return errors.As(err, &ne) && ne.Temporary()
+}
 
+// loop starts accepting connections on each socket in the background.
+// The sockets are closed if they cannot be started.
+func (i *Inetd) loop() error {
+	if i.config.Mode == Wait {
+		for _, s := range i.sockets {
+			// Must be created while the socket is still non-blocking
+			// so the dup can be used with Go's poller.
+			var err error
+			s.lfile, err = s.file()
+			if err != nil {
+				i.Close()
+				return err
+			}
+		}
+	}
+
+	for _, s := range i.sockets {
+		s := s
+		next := func() error { return i.accept(s.listener) }
+		if s.packet != nil {
+			next = func() error { return i.receive(s.packet) }
+		}
+		if i.config.Mode == Wait {
+			next = func() error { return i.wait(s) }
+		}
+		i.serveSocket(next)
+	}
+
+	return nil
+}
+
+// serveSocket calls next until the socket is closed.
+func (i *Inetd) serveSocket(next func() error) {
+	i.wg.Add(1)
 	go func() {
+		defer i.wg.Done()
+		var delay time.Duration
 		for {
-			if err := i.accept(); err != nil {
-				if !neterror.IsClosed(err) {
-					fmt.Fprintf(os.Stderr, "inetd: accept failed: %s\n", err)
-				}
+			err := next()
+			if err == nil {
+				delay = 0
+				continue
+			}
+			if neterror.IsClosed(err) {
+				return
+			}
+			if serr, ok := err.(startError); ok {
+				i.observer.Observe(ErrorEvent{Op: "start", Err: serr.err})
+			} else {
+				i.observer.Observe(ErrorEvent{Op: "accept", Err: err})
+			}
+			if !temporary(err) {
 				return
 			}
+
+			// Back off on errors such as EMFILE like net/http does
+			// rather than spinning or giving up entirely.
+			if delay == 0 {
+				delay = minBackoff
+			} else if delay *= 2; delay > maxBackoff {
+				delay = maxBackoff
+			}
+			time.Sleep(delay)
 		}
 	}()
-
-	return i, nil
 }
 
-func (i *Inetd) accept() error {
-	conn, err := i.listener.Accept()
+func (i *Inetd) accept(listener net.Listener) error {
+	conn, err := listener.Accept()
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
+	i.observer.Observe(AcceptEvent{
+		Local:  conn.LocalAddr(),
+		Remote: conn.RemoteAddr(),
+	})
+
+	i.wg.Add(1)
+	go func() {
+		defer i.wg.Done()
+		i.serve(conn)
+	}()
+
+	return nil
+}
+
+func (i *Inetd) serve(conn net.Conn) {
+	if i.config.ProxyProtocol {
+		pconn, err := readProxyHeader(i.ctx, conn)
+		if err != nil {
+			i.reject(conn, err)
+			conn.Close()
+			return
+		}
+		conn = pconn
+	}
+
+	if err := i.permit(connPeer(conn)); err != nil {
+		i.reject(conn, err)
+		conn.Close()
+		return
+	}
+
+	source := sourceAddr(conn.RemoteAddr())
+	if err := i.acquire(source); err != nil {
+		i.reject(conn, err)
+		conn.Close()
+		return
+	}
+	defer i.release(source)
+
+	if i.config.Faults != nil {
+		conn = newFaultConn(conn, i.config.Faults)
+	}
+
+	if i.config.TLSConfig != nil {
+		tconn, err := i.handshake(conn)
+		if err != nil {
+			i.observer.Observe(ErrorEvent{Op: "handshake", Err: err})
+			conn.Close()
+			return
+		}
+		conn = tconn
+	}
+
+	if i.config.Record {
+		conn = &recordConn{Conn: conn, t: i.newTranscript(conn.RemoteAddr())}
+	}
 
-	// os.exec will reuse the fd from os.File but not net.Conn
-	var fconn *os.File
-	switch conn := conn.(type) {
-	case *net.TCPConn:
-		fconn, err = conn.File()
-	case *net.UnixConn:
-		fconn, err = conn.File()
-	default:
-		err = fmt.Errorf("unknown connection type: %T", conn)
+	if i.handler != nil {
+		i.handle(conn)
+		return
 	}
+
+	started := time.Now()
+	remote := conn.RemoteAddr()
+	cmd, r, err := i.start(conn)
 	if err != nil {
-		return err
+		conn.Close()
+		i.observer.Observe(ErrorEvent{Op: "start", Err: err})
+		return
+	}
+	if r == nil {
+		// The child has its own copy now.
+		conn.Close()
+	}
+
+	i.reap(cmd, remote, started)
+	r.wait()
+}
+
+// start runs a child for conn. If conn must be relayed the relay is
+// returned and is responsible for closing conn.
+func (i *Inetd) start(conn net.Conn) (*exec.Cmd, *relay, error) {
+	fconn, r, err := i.connFile(conn)
+	if err != nil {
+		return nil, nil, err
 	}
 	defer fconn.Close()
 
-	cmd := exec.Command(i.program, i.args...)
-	cmd.Stdin = fconn
-	cmd.Stdout = fconn
-	cmd.Stderr = os.Stderr
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("inetd: %s", err)
+	var cmd *exec.Cmd
+	if i.config.Mode == Accept {
+		cmd = activationCommand(fconn, "connection", i.program, i.args...)
+	} else {
+		cmd = exec.Command(i.program, i.args...)
+		cmd.Stdin = fconn
+		cmd.Stdout = fconn
 	}
 
-	go func() {
-		if err := cmd.Wait(); err != nil {
-			fmt.Fprintf(os.Stderr, "inetd: %s exited with %s\n", i.program, err)
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, connEnv(conn)...)
+
+	if rc, ok := conn.(*recordConn); ok {
+		cmd.Stderr = io.MultiWriter(i.stderr(), rc.t.writer(Stderr))
+	}
+
+	if err := i.run(cmd, conn.RemoteAddr()); err != nil {
+		r.close()
+		return nil, nil, err
+	}
+
+	r.start()
+	return cmd, r, nil
+}
+
+// run configures and starts cmd then adds it to the set of running children.
+func (i *Inetd) run(cmd *exec.Cmd, remote net.Addr) error {
+	if len(i.config.Env) != 0 {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
 		}
-	}()
+		cmd.Env = append(cmd.Env, i.config.Env...)
+	}
+	cmd.Dir = i.config.Dir
+	if cmd.Stderr == nil {
+		cmd.Stderr = i.stderr()
+	}
+	if i.config.SysProcAttr != nil {
+		attr := *i.config.SysProcAttr
+		cmd.SysProcAttr = &attr
+	}
 
+	if err := i.setRlimits(cmd); err != nil {
+		return err
+	}
+
+	cg, err := i.newCgroup()
+	if err != nil {
+		return err
+	}
+	cg.apply(cmd)
+
+	if err := cmd.Start(); err != nil {
+		cg.remove()
+		return err
+	}
+
+	i.track(cmd.Process, cg)
+	i.observer.Observe(StartEvent{
+		Program: i.program,
+		Pid:     cmd.Process.Pid,
+		Remote:  remote,
+	})
 	return nil
 }
 
+func (i *Inetd) stderr() io.Writer {
+	if i.config.Stderr != nil {
+		return i.config.Stderr
+	}
+	return os.Stderr
+}
+
+// track adds c to the set of running children. The cgroup, if any, is
+// removed by untrack.
+func (i *Inetd) track(c child, cg *cgroup) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	t := &tracked{cgroup: cg}
+	if i.config.Timeout > 0 {
+		t.timer = time.AfterFunc(i.config.Timeout, func() {
+			i.mu.Lock()
+			t.timedOut = true
+			i.mu.Unlock()
+			c.Signal(syscall.SIGKILL)
+		})
+	}
+
+	i.children[c] = t
+	if i.stop != nil {
+		// Shutdown began while we were starting.
+		c.Signal(i.stop)
+	}
+}
+
+// untrack removes c from the set of running children and reports if it
+// was killed for exceeding Timeout.
+func (i *Inetd) untrack(c child) bool {
+	i.mu.Lock()
+	t := i.children[c]
+	delete(i.children, c)
+	i.mu.Unlock()
+
+	if t == nil {
+		return false
+	}
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.cgroup.remove()
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return t.timedOut
+}
+
+// reap waits for cmd to exit and removes it from the set of children.
+func (i *Inetd) reap(cmd *exec.Cmd, remote net.Addr, started time.Time) {
+	err := cmd.Wait()
+	if i.untrack(cmd.Process) {
+		err = ErrTimeout
+	}
+	i.observer.Observe(ExitEvent{
+		Program:  i.program,
+		Pid:      cmd.Process.Pid,
+		Remote:   remote,
+		Err:      err,
+		State:    cmd.ProcessState,
+		Duration: time.Since(started),
+	})
+}
+
 func (i *Inetd) Dial() (net.Conn, error) {
-	addr := i.listener.Addr()
+	addr := i.Addr()
 	return net.Dial(addr.Network(), addr.String())
 }
 
+// Addr returns the address of the first socket.
 func (i *Inetd) Addr() net.Addr {
-	return i.listener.Addr()
+	return i.sockets[0].addr()
+}
+
+// Addrs returns the addresses of all sockets.
+func (i *Inetd) Addrs() []net.Addr {
+	addrs := make([]net.Addr, len(i.sockets))
+	for n, s := range i.sockets {
+		addrs[n] = s.addr()
+	}
+	return addrs
+}
+
+// Active returns the number of running children.
+func (i *Inetd) Active() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return len(i.children)
 }
 
+// Close stops accepting new connections and rejects any held by Queue.
+// Running children are left alone.
 func (i *Inetd) Close() error {
-	return i.listener.Close()
+	i.mu.Lock()
+	i.closed = true
+	i.cond.Broadcast()
+	i.mu.Unlock()
+
+	var err error
+	for _, s := range i.sockets {
+		if serr := s.close(); err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+// Drain stops accepting new connections and waits for running children
+// to exit on their own. If the context expires first its error is
+// returned and the children are left running, see Shutdown.
+func (i *Inetd) Drain(ctx context.Context) error {
+	err := i.Close()
+	if neterror.IsClosed(err) {
+		err = nil
+	}
+
+	waited := make(chan struct{})
+	go func() {
+		i.wg.Wait()
+		close(waited)
+	}()
+
+	select {
+	case <-waited:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown stops accepting new connections, sends SIGTERM to all running
+// children and waits for them to exit. If the context expires first the
+// remaining children are sent SIGKILL and the context's error is returned
+// once they have been reaped.
+func (i *Inetd) Shutdown(ctx context.Context) error {
+	err := i.Close()
+	if neterror.IsClosed(err) {
+		err = nil
+	}
+
+	// Connections still queued or starting see stop once it is set.
+	i.signal(syscall.SIGTERM)
+
+	waited := make(chan struct{})
+	go func() {
+		i.wg.Wait()
+		close(waited)
+	}()
+
+	select {
+	case <-waited:
+		return err
+	case <-ctx.Done():
+		i.signal(syscall.SIGKILL)
+		<-waited
+		return ctx.Err()
+	}
+}
+
+func (i *Inetd) signal(sig os.Signal) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.stop = sig
+	i.cancel()
+	i.cond.Broadcast()
+	for c := range i.children {
+		// ignore errors, the process may have already exited.
+		c.Signal(sig)
+	}
 }
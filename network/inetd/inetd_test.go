@@ -15,10 +15,17 @@
 package inetd
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestListen(t *testing.T) {
@@ -60,3 +67,221 @@ func TestDial(t *testing.T) {
 		t.Errorf("%s != %s", string(writedata), string(readdata))
 	}
 }
+
+func TestConfigEnv(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stderr bytes.Buffer
+	config := Config{
+		Env:    []string{"INETD_TEST=env"},
+		Dir:    dir,
+		Stderr: &stderr,
+	}
+	i, err := ListenConfig(config, "tcp", "localhost:0",
+		"sh", "-c", `echo "$INETD_TEST $(pwd -P)"; echo err >&2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	data, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := "env " + dir + "\n"; string(data) != expect {
+		t.Errorf("got %q, expected %q", data, expect)
+	}
+
+	if err := i.Shutdown(testContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	if stderr.String() != "err\n" {
+		t.Errorf("got stderr %q, expected %q", stderr.String(), "err\n")
+	}
+}
+
+// testContext returns a context for Shutdown which expires well before
+// the test itself would time out.
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// dialReady connects and waits for the child to write a line.
+func dialReady(t *testing.T, i *Inetd) net.Conn {
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bufio.NewReader(c).ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestShutdown(t *testing.T) {
+	i, err := Listen("tcp", "localhost:0", "sh", "-c", "echo ready; cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := dialReady(t, i)
+	defer c.Close()
+	if n := i.Active(); n != 1 {
+		t.Errorf("%d active children, expected 1", n)
+	}
+
+	if err := i.Shutdown(testContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	if n := i.Active(); n != 0 {
+		t.Errorf("%d active children after Shutdown", n)
+	}
+	if _, err := i.Dial(); err == nil {
+		t.Error("Dial succeeded after Shutdown")
+	}
+}
+
+func TestShutdownKill(t *testing.T) {
+	i, err := Listen("tcp", "localhost:0", "sh", "-c", "trap '' TERM; echo ready; read x")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := dialReady(t, i)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := i.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown returned %v, expected %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	observer, events := eventRecorder()
+	config := Config{Timeout: 100 * time.Millisecond, Observer: observer}
+	i, err := ListenConfig(config, "tcp", "localhost:0", "sh", "-c", "echo ready; read x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	c := dialReady(t, i)
+	defer c.Close()
+
+	for {
+		if e, ok := nextEvent(t, events).(ExitEvent); ok {
+			if e.Err != ErrTimeout {
+				t.Errorf("got exit %v, expected %v", e.Err, ErrTimeout)
+			}
+			break
+		}
+	}
+}
+
+// flakyListener fails with a temporary error before each Accept.
+type flakyListener struct {
+	net.Listener
+	fail bool
+}
+
+func (f *flakyListener) Accept() (net.Conn, error) {
+	if f.fail = !f.fail; f.fail {
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Err: syscall.EMFILE}
+	}
+	return f.Listener.Accept()
+}
+
+func TestAcceptBackoff(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	observer, events := eventRecorder()
+	i, err := newInetd(Config{Observer: observer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.sockets = []*socket{{listener: &flakyListener{Listener: listener}}}
+	i.program = "echo"
+	i.args = []string{"hello"}
+	if err := i.loop(); err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	for n := 0; n < 2; n++ {
+		c, err := i.Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(c)
+		c.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "hello\n" {
+			t.Errorf("got %q, expected %q", data, "hello\n")
+		}
+	}
+
+	if e, ok := nextEvent(t, events).(ErrorEvent); !ok || !errors.Is(e.Err, syscall.EMFILE) {
+		t.Errorf("got %v, expected EMFILE error", e)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "sock")
+	for _, a := range []Address{
+		{"tcp", "localhost:0"},
+		{"unix", sock},
+	} {
+		t.Run(a.Network, func(t *testing.T) {
+			i, err := Listen(a.Network, a.Address, "sh", "-c", "echo ready; read x; echo done")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := dialReady(t, i)
+			defer c.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			if err := i.Drain(ctx); err != context.DeadlineExceeded {
+				t.Fatalf("Drain returned %v, expected %v", err, context.DeadlineExceeded)
+			}
+			if _, err := i.Dial(); err == nil {
+				t.Error("Dial succeeded while draining")
+			}
+
+			// The child is still running and finishes normally.
+			c.Write([]byte("\n"))
+			data, err := ioutil.ReadAll(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != "done\n" {
+				t.Errorf("got %q, expected %q", data, "done\n")
+			}
+			if err := i.Drain(testContext(t)); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	// Without Handoff the socket is removed along with the listener.
+	if _, err := os.Stat(sock); !os.IsNotExist(err) {
+		t.Errorf("%s not removed: %v", sock, err)
+	}
+}
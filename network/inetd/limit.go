@@ -0,0 +1,136 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// Reasons a connection may be rejected, see RejectEvent.
+var (
+	ErrLimit    = errors.New("inetd: too many connections")
+	ErrShutdown = errors.New("inetd: shutting down")
+	ErrRate     = errors.New("inetd: connection rate exceeded")
+	ErrDenied   = errors.New("inetd: access denied")
+)
+
+// ErrLooping is reported in ErrorEvent when a Wait mode child exits
+// leaving connections or datagrams pending, restarts are then delayed.
+var ErrLooping = errors.New("inetd: child exited without handling pending input")
+
+// defaultQueueLimit is used when Config.QueueLimit is zero.
+const defaultQueueLimit = 128
+
+// ErrTimeout is reported in ExitEvent for children killed by Timeout.
+var ErrTimeout = errors.New("inetd: child exceeded timeout")
+
+// sourceAddr returns the key used for PerSource accounting. For IP
+// based networks this is the remote IP without the port, for anything
+// else all connections share the same key.
+func sourceAddr(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// available reports if source may start another child. Caller must
+// hold i.mu.
+func (i *Inetd) available(source string) bool {
+	if i.config.Instances > 0 && i.active >= i.config.Instances {
+		return false
+	}
+	if i.config.PerSource > 0 && i.sources[source] >= i.config.PerSource {
+		return false
+	}
+	return true
+}
+
+// acquire reserves a slot for source, waiting for one to free up if
+// Config.Queue is set. Returns an error if the connection must be rejected.
+func (i *Inetd) acquire(source string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.stop == nil && !i.allowRate(source, time.Now()) {
+		return ErrRate
+	}
+	for i.stop == nil && !i.available(source) {
+		if i.closed {
+			return ErrShutdown
+		}
+		if !i.config.Queue || i.queued >= i.queueLimit() {
+			return ErrLimit
+		}
+		i.queued++
+		i.cond.Wait()
+		i.queued--
+	}
+	if i.stop != nil {
+		return ErrShutdown
+	}
+
+	i.active++
+	i.sources[source]++
+	return nil
+}
+
+func (i *Inetd) queueLimit() int {
+	if i.config.QueueLimit > 0 {
+		return i.config.QueueLimit
+	}
+	return defaultQueueLimit
+}
+
+func (i *Inetd) release(source string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.active--
+	if i.sources[source]--; i.sources[source] <= 0 {
+		delete(i.sources, source)
+	}
+	i.cond.Broadcast()
+}
+
+// reject reports a connection dropped without starting a child and
+// writes Banner to it.
+func (i *Inetd) reject(conn net.Conn, err error) {
+	i.countReject(conn.RemoteAddr(), err)
+	if i.config.Banner != "" {
+		// ignore errors, the connection is being dropped anyway.
+		io.WriteString(conn, i.config.Banner)
+	}
+}
+
+// countReject counts and reports a connection or datagram dropped
+// without starting a child.
+func (i *Inetd) countReject(remote net.Addr, err error) {
+	i.rejects.Add(1)
+	i.observer.Observe(RejectEvent{Remote: remote, Err: err})
+}
+
+// Rejected returns the number of connections or datagrams dropped
+// without starting a child.
+func (i *Inetd) Rejected() uint64 {
+	return i.rejects.Load()
+}
@@ -0,0 +1,128 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"bufio"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestInstancesReject(t *testing.T) {
+	config := Config{Instances: 1, Banner: "busy\n"}
+	i, err := ListenConfig(config, "tcp", "localhost:0", "sh", "-c", "echo ready; cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	c1 := dialReady(t, i)
+	defer c1.Close()
+
+	c2, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+
+	data, err := ioutil.ReadAll(c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != config.Banner {
+		t.Errorf("got %q, expected %q", data, config.Banner)
+	}
+}
+
+func TestPerSourceQueue(t *testing.T) {
+	config := Config{PerSource: 1, Queue: true}
+	i, err := ListenConfig(config, "tcp", "localhost:0", "sh", "-c", "echo ready; cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Drain(testContext(t))
+
+	c1 := dialReady(t, i)
+
+	c2, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+
+	ready := make(chan error, 1)
+	go func() {
+		_, err := bufio.NewReader(c2).ReadString('\n')
+		ready <- err
+	}()
+
+	select {
+	case err := <-ready:
+		t.Fatalf("second connection started early: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	c1.Close()
+	if err := <-ready; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueueClose(t *testing.T) {
+	observer, events := eventRecorder()
+	config := Config{Instances: 1, Queue: true, QueueLimit: 1, Observer: observer}
+	i, err := ListenConfig(config, "tcp", "localhost:0", "sh", "-c", "echo ready; cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Drain(testContext(t))
+
+	c1 := dialReady(t, i)
+	defer c1.Close()
+
+	// One waits in the queue, the other finds it full.
+	for n := 0; n < 2; n++ {
+		c, err := i.Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		for {
+			if _, ok := nextEvent(t, events).(AcceptEvent); ok {
+				break
+			}
+		}
+	}
+	if err := nextReject(t, events); err != ErrLimit {
+		t.Fatalf("got %v, expected %v", err, ErrLimit)
+	}
+
+	// Close releases the queued connection.
+	if err := i.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := nextReject(t, events); err != ErrShutdown {
+		t.Fatalf("got %v, expected %v", err, ErrShutdown)
+	}
+}
+
+func nextReject(t *testing.T, events <-chan Event) error {
+	for {
+		if e, ok := nextEvent(t, events).(RejectEvent); ok {
+			return e.Err
+		}
+	}
+}
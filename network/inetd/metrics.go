@@ -0,0 +1,244 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// durationBuckets are the upper bounds in seconds of the session
+// duration histogram.
+var durationBuckets = []float64{0.01, 0.1, 1, 10, 60, 600, 3600}
+
+// rejectReasons labels the rejected connection counter.
+var rejectReasons = []struct {
+	err    error
+	reason string
+}{
+	{ErrLimit, "limit"},
+	{ErrShutdown, "shutdown"},
+	{ErrRate, "rate"},
+	{ErrDenied, "denied"},
+	{ErrProxyHeader, "proxy"},
+}
+
+// Metrics counts the events of one or more Inetds and serves them in
+// the Prometheus text exposition format. Use Observer to create the
+// Observer for each Inetd.
+type Metrics struct {
+	mu       sync.Mutex
+	services map[string]*serviceMetrics
+}
+
+type serviceMetrics struct {
+	accepted      uint64
+	rejected      map[string]uint64 // by reason
+	spawnFailures uint64
+	errors        map[string]uint64 // by op, other than start
+	active        int64
+	exited        map[string]uint64 // by status
+	durations     histogram
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative, the last is +Inf
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(durationBuckets)+1)
+	}
+	b := sort.SearchFloat64s(durationBuckets, v)
+	h.counts[b]++
+	h.sum += v
+	h.count++
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{services: make(map[string]*serviceMetrics)}
+}
+
+// Observer returns an Observer recording events under the given
+// service label.
+func (m *Metrics) Observer(service string) Observer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.services[service] == nil {
+		m.services[service] = &serviceMetrics{
+			rejected: make(map[string]uint64),
+			errors:   make(map[string]uint64),
+			exited:   make(map[string]uint64),
+		}
+	}
+	s := m.services[service]
+	return ObserverFunc(func(e Event) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		s.observe(e)
+	})
+}
+
+func (s *serviceMetrics) observe(e Event) {
+	switch e := e.(type) {
+	case AcceptEvent:
+		s.accepted++
+	case RejectEvent:
+		s.rejected[rejectReason(e.Err)]++
+	case StartEvent:
+		s.active++
+	case ExitEvent:
+		s.active--
+		s.exited[exitStatus(e)]++
+		s.durations.observe(e.Duration.Seconds())
+	case ErrorEvent:
+		if e.Op == "start" {
+			s.spawnFailures++
+		} else {
+			s.errors[e.Op]++
+		}
+	}
+}
+
+func rejectReason(err error) string {
+	for _, r := range rejectReasons {
+		if errors.Is(err, r.err) {
+			return r.reason
+		}
+	}
+	return "other"
+}
+
+// exitStatus labels an exit by code, signal name, "timeout" or "error".
+func exitStatus(e ExitEvent) string {
+	if errors.Is(e.Err, ErrTimeout) {
+		return "timeout"
+	}
+	if e.State == nil {
+		if e.Err != nil {
+			return "error"
+		}
+		return "0"
+	}
+	if code := e.State.ExitCode(); code >= 0 {
+		return strconv.Itoa(code)
+	}
+	if ws, ok := e.State.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return ws.Signal().String()
+	}
+	return "error"
+}
+
+// WriteText writes all metrics in the Prometheus text format.
+func (m *Metrics) WriteText(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var names []string
+	for name := range m.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	family := func(name, typ, help string, each func(service string, s *serviceMetrics)) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		for _, service := range names {
+			each(service, m.services[service])
+		}
+	}
+
+	family("inetd_connections_accepted_total", "counter", "Connections accepted.",
+		func(service string, s *serviceMetrics) {
+			fmt.Fprintf(bw, "inetd_connections_accepted_total{service=%s} %d\n", label(service), s.accepted)
+		})
+	family("inetd_connections_rejected_total", "counter", "Connections rejected without starting a child.",
+		func(service string, s *serviceMetrics) {
+			for _, reason := range sortedKeys(s.rejected) {
+				fmt.Fprintf(bw, "inetd_connections_rejected_total{service=%s,reason=%s} %d\n",
+					label(service), label(reason), s.rejected[reason])
+			}
+		})
+	family("inetd_spawn_failures_total", "counter", "Children which failed to start.",
+		func(service string, s *serviceMetrics) {
+			fmt.Fprintf(bw, "inetd_spawn_failures_total{service=%s} %d\n", label(service), s.spawnFailures)
+		})
+	family("inetd_errors_total", "counter", "Other errors by operation.",
+		func(service string, s *serviceMetrics) {
+			for _, op := range sortedKeys(s.errors) {
+				fmt.Fprintf(bw, "inetd_errors_total{service=%s,op=%s} %d\n", label(service), label(op), s.errors[op])
+			}
+		})
+	family("inetd_children_active", "gauge", "Children currently running.",
+		func(service string, s *serviceMetrics) {
+			fmt.Fprintf(bw, "inetd_children_active{service=%s} %d\n", label(service), s.active)
+		})
+	family("inetd_children_exited_total", "counter", "Children which exited by exit status or signal.",
+		func(service string, s *serviceMetrics) {
+			for _, status := range sortedKeys(s.exited) {
+				fmt.Fprintf(bw, "inetd_children_exited_total{service=%s,status=%s} %d\n",
+					label(service), label(status), s.exited[status])
+			}
+		})
+	family("inetd_session_duration_seconds", "histogram", "Time children ran for.",
+		func(service string, s *serviceMetrics) {
+			h := &s.durations
+			var cumulative uint64
+			for b, le := range durationBuckets {
+				if h.counts != nil {
+					cumulative += h.counts[b]
+				}
+				fmt.Fprintf(bw, "inetd_session_duration_seconds_bucket{service=%s,le=%s} %d\n",
+					label(service), label(strconv.FormatFloat(le, 'g', -1, 64)), cumulative)
+			}
+			fmt.Fprintf(bw, "inetd_session_duration_seconds_bucket{service=%s,le=\"+Inf\"} %d\n", label(service), h.count)
+			fmt.Fprintf(bw, "inetd_session_duration_seconds_sum{service=%s} %g\n", label(service), h.sum)
+			fmt.Fprintf(bw, "inetd_session_duration_seconds_count{service=%s} %d\n", label(service), h.count)
+		})
+
+	return bw.Flush()
+}
+
+// ServeHTTP serves the metrics, typically as /metrics.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteText(w)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// label quotes a label value.
+func label(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
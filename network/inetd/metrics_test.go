@@ -0,0 +1,96 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	o := m.Observer("echo")
+	o.Observe(AcceptEvent{})
+	o.Observe(RejectEvent{Err: ErrLimit})
+	o.Observe(ErrorEvent{Op: "start"})
+	o.Observe(ErrorEvent{Op: "accept"})
+	o.Observe(StartEvent{})
+	o.Observe(StartEvent{})
+	o.Observe(ExitEvent{Duration: 50 * time.Millisecond})
+	m.Observer("a \"b\"").Observe(AcceptEvent{})
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE inetd_connections_accepted_total counter",
+		`inetd_connections_accepted_total{service="a \"b\""} 1`,
+		`inetd_connections_accepted_total{service="echo"} 1`,
+		`inetd_connections_rejected_total{service="echo",reason="limit"} 1`,
+		`inetd_spawn_failures_total{service="echo"} 1`,
+		`inetd_errors_total{service="echo",op="accept"} 1`,
+		`inetd_children_active{service="echo"} 1`,
+		`inetd_children_exited_total{service="echo",status="0"} 1`,
+		`inetd_session_duration_seconds_bucket{service="echo",le="0.01"} 0`,
+		`inetd_session_duration_seconds_bucket{service="echo",le="0.1"} 1`,
+		`inetd_session_duration_seconds_bucket{service="echo",le="+Inf"} 1`,
+		`inetd_session_duration_seconds_sum{service="echo"} 0.05`,
+		`inetd_session_duration_seconds_count{service="echo"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %q", line)
+		}
+	}
+	if t.Failed() {
+		t.Logf("metrics:\n%s", body)
+	}
+}
+
+func TestMetricsExitStatus(t *testing.T) {
+	m := NewMetrics()
+	config := Config{
+		Observer: MultiObserver(m.Observer("test"), &logObserver{w: ioutil.Discard}),
+	}
+	i, err := ListenConfig(config, "tcp", "localhost:0", "sh", "-c", "exit 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(c)
+	c.Close()
+	if err := i.Shutdown(testContext(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	if err := m.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`inetd_children_exited_total{service="test",status="3"} 1`,
+		`inetd_children_active{service="test"} 0`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, b.String())
+		}
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Largest datagram inetd will read, and reply with on unixgram.
+const maxDatagram = 65535
+
+// Largest UDP payloads, the 16 bit length less the IP and UDP headers.
+const (
+	maxUDP4 = 65535 - 20 - 8
+	maxUDP6 = 65535 - 8
+)
+
+func ListenPacket(network, address, program string, arg ...string) (*Inetd, error) {
+	return ListenPacketConfig(Config{}, network, address, program, arg...)
+}
+
+// ListenPacketConfig is like ListenConfig for datagram networks such as
+// udp and unixgram. In Nowait mode each datagram is read by inetd and
+// written to a new child's stdin, anything the child writes to stdout is
+// sent back to the sender as a single reply. In Wait mode the socket
+// itself is passed to the child which must read the datagrams.
+func ListenPacketConfig(config Config, network, address, program string, arg ...string) (*Inetd, error) {
+	return listenPacketAll(config, []Address{{network, address}}, nil, program, arg)
+}
+
+// ListenPacketAll is like ListenPacketConfig but listens on several
+// addresses at once, see ListenAll.
+func ListenPacketAll(config Config, addrs []Address, program string, arg ...string) (*Inetd, error) {
+	return listenPacketAll(config, addrs, net.DefaultResolver.LookupNetIP, program, arg)
+}
+
+func listenPacketAll(config Config, addrs []Address, lookup lookupFunc, program string, arg []string) (*Inetd, error) {
+	if err := config.checkPacket(); err != nil {
+		return nil, err
+	}
+
+	i, err := newInetd(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := i.listen(addrs, true, lookup); err != nil {
+		return nil, err
+	}
+
+	i.program = program
+	i.args = arg
+	if err := i.loop(); err != nil {
+		return nil, err
+	}
+
+	return i, nil
+}
+
+// checkPacket rejects settings which datagram sockets cannot support.
+func (c *Config) checkPacket() error {
+	switch {
+	case c.Mode == Accept:
+		return errors.New("inetd: Accept mode requires a stream network")
+	case c.TLSConfig != nil:
+		return errors.New("inetd: TLS requires a stream network")
+	case c.ProxyProtocol:
+		return errors.New("inetd: PROXY protocol requires a stream network")
+	case c.Faults != nil:
+		return errors.New("inetd: Faults require a stream network")
+	}
+	return c.checkWait()
+}
+
+func (i *Inetd) receive(pc net.PacketConn) error {
+	buf := make([]byte, maxDatagram)
+	n, remote, err := pc.ReadFrom(buf)
+	if err != nil {
+		return err
+	}
+	i.observer.Observe(AcceptEvent{
+		Local:  pc.LocalAddr(),
+		Remote: remote,
+	})
+
+	i.wg.Add(1)
+	go func() {
+		defer i.wg.Done()
+		i.serveDatagram(pc, buf[:n], remote)
+	}()
+
+	return nil
+}
+
+func (i *Inetd) serveDatagram(pc net.PacketConn, data []byte, remote net.Addr) {
+	if err := i.permit(peer{ip: addrIP(remote)}); err != nil {
+		i.countReject(remote, err)
+		return
+	}
+
+	source := sourceAddr(remote)
+	if err := i.acquire(source); err != nil {
+		i.countReject(remote, err)
+		i.reply(pc, []byte(i.config.Banner), remote)
+		return
+	}
+	defer i.release(source)
+
+	out := &limitedBuffer{n: maxReply(remote)}
+	cmd := exec.Command(i.program, i.args...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = out
+	cmd.Env = append(os.Environ(), addrEnv(pc.LocalAddr(), remote)...)
+
+	var t *Transcript
+	if i.config.Record {
+		t = i.newTranscript(remote)
+		t.add(Received, data)
+		cmd.Stderr = io.MultiWriter(i.stderr(), t.writer(Stderr))
+	}
+
+	started := time.Now()
+	if err := i.run(cmd, remote); err != nil {
+		i.observer.Observe(ErrorEvent{Op: "start", Err: err})
+		return
+	}
+	i.reap(cmd, remote, started)
+
+	if t != nil && out.Len() != 0 {
+		t.add(Sent, out.Bytes())
+	}
+	i.reply(pc, out.Bytes(), remote)
+}
+
+func (i *Inetd) reply(pc net.PacketConn, data []byte, remote net.Addr) {
+	// unixgram senders may not have an address to reply to.
+	if len(data) == 0 || remote == nil || remote.String() == "" {
+		return
+	}
+	if n := maxReply(remote); len(data) > n {
+		data = data[:n]
+	}
+	if _, err := pc.WriteTo(data, remote); err != nil {
+		i.observer.Observe(ErrorEvent{Op: "reply", Err: err})
+	}
+}
+
+// maxReply returns the largest datagram which can be sent to addr.
+func maxReply(addr net.Addr) int {
+	if udp, ok := addr.(*net.UDPAddr); ok {
+		if udp.IP.To4() != nil {
+			return maxUDP4
+		}
+		return maxUDP6
+	}
+	return maxDatagram
+}
+
+// limitedBuffer keeps the first n bytes written and discards the rest,
+// without failing the write so the child isn't cut short.
+type limitedBuffer struct {
+	bytes.Buffer
+	n int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.n - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// exchange sends a datagram and returns the reply.
+func exchange(t *testing.T, c net.Conn, msg string) string {
+	if err := c.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write([]byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 512)
+	n, err := c.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestListenPacket(t *testing.T) {
+	i, err := ListenPacket("udp", "127.0.0.1:0", "sh", "-c", `echo $PROTO; tr a-z A-Z`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if reply := exchange(t, c, "test"); reply != "UDP\nTEST" {
+		t.Errorf("got %q, expected %q", reply, "UDP\nTEST")
+	}
+}
+
+func TestListenPacketWait(t *testing.T) {
+	program, args := helperCommand(t, "read-one")
+	config := Config{Mode: Wait, Name: "dgram"}
+	i, err := ListenPacketConfig(config, "udp", "127.0.0.1:0", program, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// The child exits after each datagram so this also checks that
+	// a new child is started for the next one.
+	for _, msg := range []string{"one", "two"} {
+		if reply := exchange(t, c, msg); reply != "dgram "+msg {
+			t.Errorf("got %q, expected %q", reply, "dgram "+msg)
+		}
+	}
+}
+
+func TestListenPacketLargeReply(t *testing.T) {
+	i, err := ListenPacket("udp", "127.0.0.1:0", "sh", "-c", `head -c 100000 /dev/zero`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write([]byte("test")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, maxDatagram)
+	n, err := c.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != maxUDP4 {
+		t.Errorf("got %d byte reply, expected %d", n, maxUDP4)
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"net"
+	"syscall"
+)
+
+type credentials struct {
+	pid, uid, gid int
+}
+
+// peerCred looks up the process on the other end of conn via SO_PEERCRED.
+func peerCred(conn *net.UnixConn) (*credentials, error) {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var ucred *syscall.Ucred
+	var uerr error
+	err = rc.Control(func(fd uintptr) {
+		ucred, uerr = syscall.GetsockoptUcred(int(fd),
+			syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if uerr != nil {
+		return nil, uerr
+	}
+
+	return &credentials{
+		pid: int(ucred.Pid),
+		uid: int(ucred.Uid),
+		gid: int(ucred.Gid),
+	}, nil
+}
@@ -0,0 +1,30 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package inetd
+
+import (
+	"errors"
+	"net"
+)
+
+type credentials struct {
+	pid, uid, gid int
+}
+
+func peerCred(conn *net.UnixConn) (*credentials, error) {
+	return nil, errors.New("peer credentials not supported")
+}
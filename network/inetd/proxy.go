@@ -0,0 +1,217 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrProxyHeader is wrapped by errors for connections without a valid
+// PROXY protocol header, see RejectEvent.
+var ErrProxyHeader = errors.New("inetd: invalid PROXY protocol header")
+
+// How long a client has to send the PROXY protocol header.
+const proxyTimeout = 10 * time.Second
+
+// Maximum length of a v1 header, including the trailing CRLF.
+const proxyV1MaxLen = 107
+
+var proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyConn overrides a connection's addresses with those received in
+// a PROXY protocol header.
+type proxyConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (p *proxyConn) LocalAddr() net.Addr {
+	return p.local
+}
+
+func (p *proxyConn) RemoteAddr() net.Addr {
+	return p.remote
+}
+
+func (p *proxyConn) CloseWrite() error {
+	if cw, ok := p.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+func proxyError(format string, a ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrProxyHeader, fmt.Sprintf(format, a...))
+}
+
+// readProxyHeader reads a v1 or v2 PROXY protocol header from conn. Only
+// the header itself is consumed so the connection can still be passed
+// to a child. If the header doesn't include addresses, such as a v2
+// LOCAL command used for health checks, conn is returned as is. Reading
+// stops early with ErrShutdown once ctx is done.
+func readProxyHeader(ctx context.Context, conn net.Conn) (net.Conn, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(proxyTimeout)); err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+	local, remote, err := parseProxyHeader(conn)
+	if !stop() {
+		return nil, ErrShutdown
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+
+	if local == nil || remote == nil {
+		return conn, nil
+	}
+	return &proxyConn{Conn: conn, local: local, remote: remote}, nil
+}
+
+// parseProxyHeader reads either header version, returning its addresses.
+func parseProxyHeader(conn net.Conn) (net.Addr, net.Addr, error) {
+	// Both "PROXY " and the start of the v2 signature are 6 bytes and
+	// the shortest possible v1 header is 15 bytes so this can't over
+	// read no matter which version the client sent.
+	start := make([]byte, 6)
+	if _, err := io.ReadFull(conn, start); err != nil {
+		return nil, nil, proxyError("%s", err)
+	}
+
+	switch {
+	case string(start) == "PROXY ":
+		return readProxyV1(conn)
+	case bytes.Equal(start, proxyV2Sig[:6]):
+		return readProxyV2(conn)
+	}
+	return nil, nil, proxyError("unknown signature %q", start)
+}
+
+// readProxyV1 parses the rest of a text header after "PROXY ".
+func readProxyV1(conn net.Conn) (net.Addr, net.Addr, error) {
+	// Reading a byte at a time is slow but avoids buffering any of
+	// the data that follows the header.
+	line := make([]byte, 0, proxyV1MaxLen)
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyV1MaxLen-6 {
+			return nil, nil, proxyError("v1 header too long")
+		}
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return nil, nil, proxyError("%s", err)
+		}
+		line = append(line, b[0])
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if fields[0] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 5 || (fields[0] != "TCP4" && fields[0] != "TCP6") {
+		return nil, nil, proxyError("malformed v1 header %q", line)
+	}
+
+	src, err := parseProxyV1Addr(fields[1], fields[3])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := parseProxyV1Addr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	return dst, src, nil
+}
+
+func parseProxyV1Addr(ip, port string) (*net.TCPAddr, error) {
+	addr := &net.TCPAddr{IP: net.ParseIP(ip)}
+	if addr.IP == nil {
+		return nil, proxyError("invalid address %q", ip)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, proxyError("invalid port %q", port)
+	}
+	addr.Port = int(p)
+	return addr, nil
+}
+
+// readProxyV2 parses the rest of a binary header after the first 6
+// bytes of the signature.
+func readProxyV2(conn net.Conn) (net.Addr, net.Addr, error) {
+	hdr := make([]byte, 10)
+	if _, err := io.ReadFull(conn, hdr); err != nil {
+		return nil, nil, proxyError("%s", err)
+	}
+	if !bytes.Equal(hdr[:6], proxyV2Sig[6:]) {
+		return nil, nil, proxyError("invalid v2 signature")
+	}
+	if hdr[6]>>4 != 2 {
+		return nil, nil, proxyError("unsupported version %d", hdr[6]>>4)
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(hdr[8:]))
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return nil, nil, proxyError("%s", err)
+	}
+
+	switch hdr[6] & 0xf {
+	case 0: // LOCAL
+		return nil, nil, nil
+	case 1: // PROXY
+	default:
+		return nil, nil, proxyError("unsupported command %d", hdr[6]&0xf)
+	}
+
+	// Only TCP over IPv4 and IPv6 are interesting, anything else is
+	// treated like UNKNOWN in v1. Any TLVs after the addresses are
+	// ignored.
+	var size int
+	switch hdr[7] {
+	case 0x11: // TCP over IPv4
+		size = net.IPv4len
+	case 0x21: // TCP over IPv6
+		size = net.IPv6len
+	default:
+		return nil, nil, nil
+	}
+	if len(body) < 2*size+4 {
+		return nil, nil, proxyError("v2 address block too short")
+	}
+
+	src := &net.TCPAddr{
+		IP:   net.IP(body[:size]),
+		Port: int(binary.BigEndian.Uint16(body[2*size:])),
+	}
+	dst := &net.TCPAddr{
+		IP:   net.IP(body[size : 2*size]),
+		Port: int(binary.BigEndian.Uint16(body[2*size+2:])),
+	}
+	return dst, src, nil
+}
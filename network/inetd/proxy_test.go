@@ -0,0 +1,175 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+// readProxy runs readProxyHeader on the server side of a pipe the
+// header and data are written to, returning the resulting connection.
+func readProxy(t *testing.T, header, data string) (net.Conn, error) {
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close(); server.Close() })
+	go func() {
+		client.Write([]byte(header + data))
+		client.Close()
+	}()
+	return readProxyHeader(context.Background(), server)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	v2 := "\r\n\r\n\x00\r\nQUIT\n"
+	for _, tt := range []struct {
+		name, header, local, remote string
+	}{
+		{"v1-tcp4", "PROXY TCP4 192.0.2.1 192.0.2.2 1234 80\r\n",
+			"192.0.2.2:80", "192.0.2.1:1234"},
+		{"v1-tcp6", "PROXY TCP6 2001:db8::1 2001:db8::2 1234 443\r\n",
+			"[2001:db8::2]:443", "[2001:db8::1]:1234"},
+		{"v1-unknown", "PROXY UNKNOWN ignored\r\n", "pipe", "pipe"},
+		{"v2-tcp4", v2 + "\x21\x11\x00\x0c" +
+			"\xc0\x00\x02\x01\xc0\x00\x02\x02\x04\xd2\x00\x50",
+			"192.0.2.2:80", "192.0.2.1:1234"},
+		{"v2-tlv", v2 + "\x21\x11\x00\x10" +
+			"\xc0\x00\x02\x01\xc0\x00\x02\x02\x04\xd2\x00\x50" +
+			"\x04\x00\x01\x00",
+			"192.0.2.2:80", "192.0.2.1:1234"},
+		{"v2-local", v2 + "\x20\x00\x00\x00", "pipe", "pipe"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := readProxy(t, tt.header, "data")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s := c.LocalAddr().String(); s != tt.local {
+				t.Errorf("local %q, expected %q", s, tt.local)
+			}
+			if s := c.RemoteAddr().String(); s != tt.remote {
+				t.Errorf("remote %q, expected %q", s, tt.remote)
+			}
+			data, err := ioutil.ReadAll(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != "data" {
+				t.Errorf("got %q after header, expected %q", data, "data")
+			}
+		})
+	}
+}
+
+func TestReadProxyHeaderInvalid(t *testing.T) {
+	for _, header := range []string{
+		"GET / HTTP/1.0\r\n",
+		"PROXY TCP4 192.0.2.1 192.0.2.2 1234\r\n",
+		"PROXY TCP4 192.0.2.1 192.0.2.2 1234 99999\r\n",
+		"PROXY TCP4 bogus 192.0.2.2 1234 80\r\n",
+		"PROXY " + strings.Repeat("x", 200),
+		"\r\n\r\n\x00\r\nQUIT\n\x11\x11\x00\x00",
+		"\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x04\x00\x00\x00\x00",
+		"",
+	} {
+		_, err := readProxy(t, header, "")
+		if !errors.Is(err, ErrProxyHeader) {
+			t.Errorf("%q: got %v, expected %v", header, err, ErrProxyHeader)
+		}
+	}
+}
+
+func TestProxyProtocol(t *testing.T) {
+	observer, events := eventRecorder()
+	config := Config{ProxyProtocol: true, Observer: observer}
+	i, err := ListenConfig(config, "tcp", "localhost:0", "sh", "-c",
+		`echo "$TCPREMOTEIP $TCPREMOTEPORT $TCPLOCALPORT"; cat`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.Write([]byte("PROXY TCP4 192.0.2.1 192.0.2.2 1234 80\r\nhello\n")); err != nil {
+		t.Fatal(err)
+	}
+	c.(*net.TCPConn).CloseWrite()
+
+	data, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "192.0.2.1 1234 80\nhello\n"
+	if string(data) != expect {
+		t.Errorf("got %q, expected %q", data, expect)
+	}
+
+	nextEvent(t, events) // accept
+	start, ok := nextEvent(t, events).(StartEvent)
+	if !ok || start.Remote.String() != "192.0.2.1:1234" {
+		t.Errorf("unexpected start event %v", start)
+	}
+
+	// Connections without a header are rejected.
+	c2, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	c2.Write([]byte("hello\n"))
+	data, err = ioutil.ReadAll(c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 0 {
+		t.Errorf("got %q from rejected connection", data)
+	}
+}
+
+func TestProxyShutdown(t *testing.T) {
+	observer, events := eventRecorder()
+	config := Config{ProxyProtocol: true, Observer: observer}
+	i, err := ListenConfig(config, "tcp", "localhost:0", "true")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Never send the header.
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if e, ok := nextEvent(t, events).(AcceptEvent); !ok {
+		t.Fatalf("expected AcceptEvent, got %T", e)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), proxyTimeout/2)
+	defer cancel()
+	if err := i.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown waited on the header: %v", err)
+	}
+	if e, ok := nextEvent(t, events).(RejectEvent); !ok || !errors.Is(e.Err, ErrShutdown) {
+		t.Errorf("expected ErrShutdown, got %v", e)
+	}
+}
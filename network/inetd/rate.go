@@ -0,0 +1,97 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"time"
+)
+
+// How often per source buckets which have refilled are discarded.
+const pruneInterval = time.Minute
+
+// bucket is a token bucket for rate limiting.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds tokens at rate per second since the last refill, up to
+// burst. A new bucket starts full.
+func (b *bucket) refill(now time.Time, rate float64, burst int) {
+	size := bucketSize(burst)
+	if b.last.IsZero() {
+		b.tokens = size
+	} else if b.tokens += now.Sub(b.last).Seconds() * rate; b.tokens > size {
+		b.tokens = size
+	}
+	b.last = now
+}
+
+// full reports if the bucket is no different from a new one.
+func (b *bucket) full(burst int) bool {
+	return b.tokens >= bucketSize(burst)
+}
+
+func bucketSize(burst int) float64 {
+	if burst < 1 {
+		return 1
+	}
+	return float64(burst)
+}
+
+// allowRate takes a token from the global and source buckets if both
+// have one available. Caller must hold i.mu.
+func (i *Inetd) allowRate(source string, now time.Time) bool {
+	if i.config.Rate > 0 {
+		i.rate.refill(now, i.config.Rate, i.config.Burst)
+		if i.rate.tokens < 1 {
+			return false
+		}
+	}
+
+	var b *bucket
+	if i.config.PerSourceRate > 0 {
+		i.pruneBuckets(now)
+		if b = i.buckets[source]; b == nil {
+			b = &bucket{}
+			i.buckets[source] = b
+		}
+		b.refill(now, i.config.PerSourceRate, i.config.PerSourceBurst)
+		if b.tokens < 1 {
+			return false
+		}
+		b.tokens--
+	}
+
+	if i.config.Rate > 0 {
+		i.rate.tokens--
+	}
+	return true
+}
+
+// pruneBuckets periodically discards buckets which have refilled so
+// the map doesn't grow with every source ever seen. Caller must hold i.mu.
+func (i *Inetd) pruneBuckets(now time.Time) {
+	if now.Sub(i.pruned) < pruneInterval {
+		return
+	}
+	i.pruned = now
+	for source, b := range i.buckets {
+		b.refill(now, i.config.PerSourceRate, i.config.PerSourceBurst)
+		if b.full(i.config.PerSourceBurst) {
+			delete(i.buckets, source)
+		}
+	}
+}
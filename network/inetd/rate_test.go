@@ -0,0 +1,96 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	var b bucket
+	now := time.Now()
+	b.refill(now, 2, 3)
+	if b.tokens != 3 {
+		t.Fatalf("new bucket has %v tokens, expected 3", b.tokens)
+	}
+	b.tokens = 0
+	b.refill(now.Add(time.Second), 2, 3)
+	if b.tokens != 2 {
+		t.Errorf("refilled %v tokens in 1s, expected 2", b.tokens)
+	}
+	b.refill(now.Add(time.Minute), 2, 3)
+	if !b.full(3) {
+		t.Errorf("bucket not full after a minute: %v tokens", b.tokens)
+	}
+}
+
+func TestAllowRate(t *testing.T) {
+	i, err := newInetd(Config{Rate: 10, Burst: 2, PerSourceRate: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if !i.allowRate("a", now) {
+		t.Fatal("first connection from a denied")
+	}
+	if i.allowRate("a", now) {
+		t.Fatal("second connection from a allowed")
+	}
+	if !i.allowRate("b", now) {
+		t.Fatal("first connection from b denied")
+	}
+	if i.allowRate("c", now) {
+		t.Fatal("connection over global burst allowed")
+	}
+	if !i.allowRate("c", now.Add(100*time.Millisecond)) {
+		t.Fatal("connection denied after global refill")
+	}
+
+	// Every bucket has refilled long after the last connection.
+	i.pruneBuckets(now.Add(2 * pruneInterval))
+	if len(i.buckets) != 0 {
+		t.Errorf("%d buckets left after pruning", len(i.buckets))
+	}
+}
+
+func TestRateReject(t *testing.T) {
+	config := Config{Rate: 0.001, Burst: 1, Banner: "slow down\n"}
+	i, err := ListenConfig(config, "tcp", "localhost:0", "echo", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	for _, expect := range []string{"hello\n", config.Banner} {
+		c, err := i.Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(c)
+		c.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expect {
+			t.Errorf("got %q, expected %q", data, expect)
+		}
+	}
+
+	if n := i.Rejected(); n != 1 {
+		t.Errorf("%d rejected, expected 1", n)
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+const pollIn = 0x1
+
+// readable polls fd without blocking. Errors are reported as readable
+// so the caller finds out about them instead of waiting forever.
+func readable(fd uintptr) bool {
+	ready, err := poll(fd)
+	return err != 0 || ready
+}
+
+// pending reports whether fd has a connection or datagram waiting.
+func pending(fd uintptr) bool {
+	ready, err := poll(fd)
+	return err == 0 && ready
+}
+
+func poll(fd uintptr) (bool, syscall.Errno) {
+	pfd := struct {
+		fd      int32
+		events  int16
+		revents int16
+	}{int32(fd), pollIn, 0}
+	var ts syscall.Timespec
+	n, _, errno := syscall.Syscall6(syscall.SYS_PPOLL,
+		uintptr(unsafe.Pointer(&pfd)), 1, uintptr(unsafe.Pointer(&ts)), 0, 0, 0)
+	return n > 0, errno
+}
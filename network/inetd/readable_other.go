@@ -0,0 +1,28 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package inetd
+
+// readable cannot be checked here so trust Go's poller, at worst a
+// Wait mode child is started without a pending connection.
+func readable(fd uintptr) bool {
+	return true
+}
+
+// pending cannot be checked either so only Rate limits restarts.
+func pending(fd uintptr) bool {
+	return false
+}
@@ -0,0 +1,156 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Direction identifies the source of recorded data.
+type Direction int
+
+const (
+	Received Direction = iota // from the client
+	Sent                      // to the client
+	Stderr                    // from the child's stderr
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Received:
+		return "recv"
+	case Sent:
+		return "send"
+	case Stderr:
+		return "stderr"
+	default:
+		return fmt.Sprintf("Direction(%d)", int(d))
+	}
+}
+
+// Record is a single read or write in a Transcript.
+type Record struct {
+	Time time.Time
+	Dir  Direction
+	Data []byte
+}
+
+// Transcript holds everything exchanged with one client in Record mode.
+type Transcript struct {
+	Remote net.Addr
+	Start  time.Time
+
+	mu      sync.Mutex
+	records []Record
+}
+
+func (t *Transcript) add(dir Direction, data []byte) {
+	r := Record{
+		Time: time.Now(),
+		Dir:  dir,
+		Data: append([]byte(nil), data...),
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.records = append(t.records, r)
+}
+
+// Records returns a copy of the data recorded so far.
+func (t *Transcript) Records() []Record {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Record(nil), t.records...)
+}
+
+// String formats the transcript with one line per record, suitable for
+// dumping when a test fails.
+func (t *Transcript) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "connection from %s at %s\n", t.Remote, t.Start.Format(time.RFC3339Nano))
+	for _, r := range t.Records() {
+		fmt.Fprintf(&b, "%12s %-6s %q\n", r.Time.Sub(t.Start).Round(time.Microsecond), r.Dir, r.Data)
+	}
+	return b.String()
+}
+
+// writer returns an io.Writer which records data in direction dir.
+func (t *Transcript) writer(dir Direction) *recordWriter {
+	return &recordWriter{t: t, dir: dir}
+}
+
+type recordWriter struct {
+	t   *Transcript
+	dir Direction
+}
+
+func (w *recordWriter) Write(p []byte) (int, error) {
+	w.t.add(w.dir, p)
+	return len(p), nil
+}
+
+// recordConn adds everything read and written to a Transcript. Since
+// the data must pass through this process it is always relayed.
+type recordConn struct {
+	net.Conn
+	t *Transcript
+}
+
+func (r *recordConn) Unwrap() net.Conn {
+	return r.Conn
+}
+
+func (r *recordConn) Read(p []byte) (int, error) {
+	n, err := r.Conn.Read(p)
+	if n > 0 {
+		r.t.add(Received, p[:n])
+	}
+	return n, err
+}
+
+func (r *recordConn) Write(p []byte) (int, error) {
+	n, err := r.Conn.Write(p)
+	if n > 0 {
+		r.t.add(Sent, p[:n])
+	}
+	return n, err
+}
+
+func (r *recordConn) CloseWrite() error {
+	if cw, ok := r.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// newTranscript starts recording a new connection or datagram.
+func (i *Inetd) newTranscript(remote net.Addr) *Transcript {
+	t := &Transcript{Remote: remote, Start: time.Now()}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.transcripts = append(i.transcripts, t)
+	return t
+}
+
+// Transcripts returns the transcripts of all connections recorded so
+// far in the order they were accepted, including those still running.
+func (i *Inetd) Transcripts() []*Transcript {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return append([]*Transcript(nil), i.transcripts...)
+}
@@ -0,0 +1,144 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+// recorded concatenates the data recorded in each direction.
+func recorded(t *Transcript) map[Direction]string {
+	data := make(map[Direction]string)
+	for _, r := range t.Records() {
+		data[r.Dir] += string(r.Data)
+	}
+	return data
+}
+
+func TestRecord(t *testing.T) {
+	config := Config{Record: true, Stderr: ioutil.Discard}
+	i, err := ListenConfig(config, "tcp", "localhost:0", "sh", "-c",
+		"read line; echo got $line; echo oops >&2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "got hello\n" {
+		t.Errorf("got %q, expected %q", data, "got hello\n")
+	}
+	if err := i.Shutdown(testContext(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	transcripts := i.Transcripts()
+	if len(transcripts) != 1 {
+		t.Fatalf("got %d transcripts, expected 1", len(transcripts))
+	}
+	tr := transcripts[0]
+	if tr.Remote.String() != c.LocalAddr().String() {
+		t.Errorf("transcript for %s, expected %s", tr.Remote, c.LocalAddr())
+	}
+	expect := map[Direction]string{
+		Received: "hello\n",
+		Sent:     "got hello\n",
+		Stderr:   "oops\n",
+	}
+	for dir, s := range recorded(tr) {
+		if s != expect[dir] {
+			t.Errorf("%s: got %q, expected %q", dir, s, expect[dir])
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(tr.String()), "\n")
+	if len(lines) != 4 || !strings.HasSuffix(lines[1], `recv   "hello\n"`) {
+		t.Errorf("unexpected transcript:\n%s", tr)
+	}
+}
+
+func TestRecordHandler(t *testing.T) {
+	config := Config{Record: true}
+	i, err := ListenHandler(config, "tcp", "localhost:0", HandlerFunc(func(ctx context.Context, conn net.Conn) {
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		conn.Write([]byte(strings.ToUpper(line)))
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Write([]byte("hello\n"))
+	if _, err := ioutil.ReadAll(c); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Shutdown(testContext(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	data := recorded(i.Transcripts()[0])
+	if data[Received] != "hello\n" || data[Sent] != "HELLO\n" {
+		t.Errorf("unexpected transcript %q", data)
+	}
+}
+
+func TestRecordPacket(t *testing.T) {
+	i, err := ListenPacketConfig(Config{Record: true}, "udp", "localhost:0", "cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if reply := exchange(t, c, "ping"); reply != "ping" {
+		t.Errorf("got %q, expected %q", reply, "ping")
+	}
+	if err := i.Shutdown(testContext(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	data := recorded(i.Transcripts()[0])
+	if data[Received] != "ping" || data[Sent] != "ping" {
+		t.Errorf("unexpected transcript %q", data)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"os"
+)
+
+// connFile returns a file for passing conn to a child. Connections which
+// are not plain sockets, such as TLS, Record or Faults, are relayed
+// through a socketpair in which case the relay must be started once the
+// child is running.
+func (i *Inetd) connFile(conn net.Conn) (*os.File, *relay, error) {
+	// os.exec will reuse the fd from os.File but not net.Conn
+	switch conn := conn.(type) {
+	case *net.TCPConn:
+		f, err := conn.File()
+		return f, nil, err
+	case *net.UnixConn:
+		f, err := conn.File()
+		return f, nil, err
+	case *proxyConn:
+		// The header has been consumed so the socket can be passed on.
+		return i.connFile(conn.Conn)
+	case *tls.Conn, *recordConn, *faultConn:
+		return newRelay(conn)
+	default:
+		return nil, nil, fmt.Errorf("unknown connection type: %T", conn)
+	}
+}
+
+// relay copies data between a client and our end of a socketpair.
+type relay struct {
+	client net.Conn
+	local  net.Conn
+	done   chan struct{} // closed once the child's output is copied
+}
+
+func newRelay(client net.Conn) (*os.File, *relay, error) {
+	local, remote, err := socketpair()
+	if err != nil {
+		return nil, nil, err
+	}
+	return remote, &relay{
+		client: client,
+		local:  local,
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// start copies data in both directions in the background.
+func (r *relay) start() {
+	if r == nil {
+		return
+	}
+	go func() {
+		io.Copy(r.local, r.client)
+		closeWrite(r.local)
+	}()
+	go func() {
+		io.Copy(r.client, r.local)
+		closeWrite(r.client)
+		close(r.done)
+	}()
+}
+
+// wait blocks until all of the child's output has been copied to the
+// client and then closes both connections.
+func (r *relay) wait() {
+	if r == nil {
+		return
+	}
+	<-r.done
+	r.close()
+}
+
+// close releases our end of the socketpair, the client connection is
+// only closed if the relay was started.
+func (r *relay) close() {
+	if r == nil {
+		return
+	}
+	select {
+	case <-r.done:
+		r.client.Close()
+	default:
+	}
+	r.local.Close()
+}
+
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+}
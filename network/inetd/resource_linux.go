@@ -0,0 +1,137 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// cgroupSeq names child cgroups, combined with our pid so multiple
+// Inetds and processes can share a parent cgroup.
+var cgroupSeq uint64
+
+// cgroup is a cgroup v2 directory created for a single child.
+type cgroup struct {
+	path string
+	dir  *os.File
+}
+
+// newCgroup creates a cgroup for a new child, or returns nil if
+// Config.Cgroup isn't set.
+func (i *Inetd) newCgroup() (*cgroup, error) {
+	if i.config.Cgroup == "" {
+		return nil, nil
+	}
+
+	name := fmt.Sprintf("inetd-%d-%d", os.Getpid(), atomic.AddUint64(&cgroupSeq, 1))
+	cg := &cgroup{path: filepath.Join(i.config.Cgroup, name)}
+	if err := os.Mkdir(cg.path, 0755); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if i.config.CgroupMemoryMax > 0 {
+		err = cg.write("memory.max", i.config.CgroupMemoryMax)
+	}
+	if err == nil && i.config.CgroupPidsMax > 0 {
+		err = cg.write("pids.max", i.config.CgroupPidsMax)
+	}
+	if err == nil {
+		cg.dir, err = os.Open(cg.path)
+	}
+	if err != nil {
+		cg.remove()
+		return nil, err
+	}
+
+	return cg, nil
+}
+
+func (cg *cgroup) write(file string, value int64) error {
+	data := []byte(strconv.FormatInt(value, 10))
+	return os.WriteFile(filepath.Join(cg.path, file), data, 0644)
+}
+
+// apply configures cmd to start directly in the cgroup.
+func (cg *cgroup) apply(cmd *exec.Cmd) {
+	if cg == nil {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(cg.dir.Fd())
+}
+
+// remove kills any processes left in the cgroup and deletes it.
+func (cg *cgroup) remove() {
+	if cg == nil {
+		return
+	}
+	if cg.dir != nil {
+		cg.dir.Close()
+	}
+
+	// cgroup.kill requires Linux 5.14, without it leftover processes
+	// keep the cgroup around until they exit on their own.
+	os.WriteFile(filepath.Join(cg.path, "cgroup.kill"), []byte("1"), 0644)
+
+	// Killed processes take a moment to leave the cgroup.
+	for tries := 0; tries < 100; tries++ {
+		err := syscall.Rmdir(cg.path)
+		if err != syscall.EBUSY {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// setRlimits applies the Rlimit settings to cmd. Go cannot set limits
+// between fork and exec so like LISTEN_PID a shell sets them, the
+// child never runs without them.
+func (i *Inetd) setRlimits(cmd *exec.Cmd) error {
+	// ulimit sets both the soft and hard limits, -v is in KiB.
+	cpu := (i.config.RlimitCPU + time.Second - 1) / time.Second
+	var script []string
+	for _, l := range []struct {
+		option string
+		value  uint64
+	}{
+		{"-t", uint64(cpu)},
+		{"-v", (i.config.RlimitAS + 1023) / 1024},
+		{"-n", i.config.RlimitNofile},
+	} {
+		if l.value != 0 {
+			script = append(script, fmt.Sprintf("ulimit %s %d", l.option, l.value))
+		}
+	}
+	if len(script) == 0 || cmd.Err != nil {
+		return nil
+	}
+
+	script = append(script, `exec "$0" "$@"`)
+	cmd.Args = append([]string{"/bin/sh", "-c", strings.Join(script, " && "), cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = "/bin/sh"
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRlimits(t *testing.T) {
+	config := Config{
+		RlimitCPU:    1500 * time.Millisecond,
+		RlimitAS:     1 << 30,
+		RlimitNofile: 64,
+	}
+	i, err := ListenConfig(config, "tcp", "localhost:0", "sh", "-c",
+		"ulimit -t; ulimit -v; ulimit -n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	data, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := "2\n1048576\n64\n"; string(data) != expect {
+		t.Errorf("got %q, expected %q", data, expect)
+	}
+}
+
+// TestCgroup requires INETD_TEST_CGROUP to name a writable cgroup v2
+// directory.
+func TestCgroup(t *testing.T) {
+	parent := os.Getenv("INETD_TEST_CGROUP")
+	if parent == "" {
+		t.Skip("INETD_TEST_CGROUP not set")
+	}
+
+	config := Config{Cgroup: parent}
+	i, err := ListenConfig(config, "tcp", "localhost:0", "sh", "-c",
+		"echo ready; cat /proc/self/cgroup; sleep 60 & read x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	c := dialReady(t, i)
+	defer c.Close()
+	c.Write([]byte("done\n"))
+
+	data, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "/inetd-") {
+		t.Errorf("child not in a new cgroup: %q", data)
+	}
+
+	// The leftover sleep is killed and the cgroup removed.
+	if err := i.Shutdown(testContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	left, err := filepath.Glob(filepath.Join(parent, "inetd-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 0 {
+		t.Errorf("cgroups not removed: %v", left)
+	}
+}
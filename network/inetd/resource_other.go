@@ -0,0 +1,42 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package inetd
+
+import (
+	"errors"
+	"os/exec"
+)
+
+type cgroup struct{}
+
+func (i *Inetd) newCgroup() (*cgroup, error) {
+	if i.config.Cgroup != "" {
+		return nil, errors.New("inetd: cgroups are only supported on Linux")
+	}
+	return nil, nil
+}
+
+func (cg *cgroup) apply(cmd *exec.Cmd) {}
+
+func (cg *cgroup) remove() {}
+
+func (i *Inetd) setRlimits(cmd *exec.Cmd) error {
+	if i.config.RlimitCPU != 0 || i.config.RlimitAS != 0 || i.config.RlimitNofile != 0 {
+		return errors.New("inetd: resource limits are only supported on Linux")
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// Address is a network and address to listen on, as passed to
+// net.Listen or net.ListenPacket.
+type Address struct {
+	Network string
+	Address string
+}
+
+// socket is one of the sockets an Inetd listens on.
+type socket struct {
+	listener net.Listener
+	packet   net.PacketConn // used instead of listener for datagrams
+	lfile    *os.File       // dup of the socket polled in Wait mode
+}
+
+func (s *socket) addr() net.Addr {
+	if s.packet != nil {
+		return s.packet.LocalAddr()
+	}
+	return s.listener.Addr()
+}
+
+func (s *socket) close() error {
+	if s.lfile != nil {
+		s.lfile.Close()
+	}
+	if s.packet != nil {
+		return s.packet.Close()
+	}
+	return s.listener.Close()
+}
+
+// file returns a dup of the socket.
+func (s *socket) file() (*os.File, error) {
+	var sock interface{} = s.listener
+	if s.packet != nil {
+		sock = s.packet
+	}
+	switch sock := sock.(type) {
+	case *net.TCPListener:
+		return sock.File()
+	case *net.UnixListener:
+		return sock.File()
+	case *net.UDPConn:
+		return sock.File()
+	case *net.UnixConn:
+		return sock.File()
+	default:
+		return nil, fmt.Errorf("unknown socket type: %T", sock)
+	}
+}
+
+// lookupFunc returns a host's IPs, like net.Resolver.LookupNetIP.
+type lookupFunc func(ctx context.Context, network, host string) ([]netip.Addr, error)
+
+// resolve expands a host name into an address for each of its IPs
+// matching the network's family. Anything else is returned as is.
+func resolve(a Address, lookup lookupFunc) ([]Address, error) {
+	var family string
+	switch a.Network {
+	case "tcp", "udp":
+	case "tcp4", "udp4":
+		family = "ip4"
+	case "tcp6", "udp6":
+		family = "ip6"
+	default:
+		return []Address{a}, nil
+	}
+
+	host, port, err := net.SplitHostPort(a.Address)
+	if err != nil {
+		return nil, err
+	}
+	if host == "" || net.ParseIP(strings.SplitN(host, "%", 2)[0]) != nil {
+		return []Address{a}, nil
+	}
+
+	ips, err := lookup(context.Background(), "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []Address
+	for _, ip := range ips {
+		ip = ip.Unmap()
+		if (family == "ip4" && !ip.Is4()) || (family == "ip6" && !ip.Is6()) {
+			continue
+		}
+		addrs = append(addrs, Address{
+			Network: a.Network,
+			Address: net.JoinHostPort(ip.String(), port),
+		})
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no %s addresses for %s", a.Network, host)
+	}
+	return addrs, nil
+}
+
+// listen opens sockets for addrs, closing them all on error. With a
+// lookup function host names are resolved and every address used,
+// otherwise each is passed to net.Listen or net.ListenPacket as is.
+func (i *Inetd) listen(addrs []Address, packet bool, lookup lookupFunc) error {
+	if len(addrs) == 0 {
+		return errors.New("inetd: no addresses to listen on")
+	}
+	for _, a := range addrs {
+		resolved := []Address{a}
+		var err error
+		if lookup != nil {
+			resolved, err = resolve(a, lookup)
+		}
+		if err == nil {
+			err = i.listenResolved(resolved, packet)
+		}
+		if err != nil {
+			i.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *Inetd) listenResolved(resolved []Address, packet bool) error {
+	// Let the system pick a port once for every address of a host so
+	// dual stack names such as localhost work as expected. If that port
+	// is taken on one of the other addresses the system picks another.
+	var port string
+	for n, r := range resolved {
+		var s *socket
+		var err error
+		if port != "" {
+			host, _, _ := net.SplitHostPort(r.Address)
+			s, err = listenSocket(Address{r.Network, net.JoinHostPort(host, port)}, packet)
+			if errors.Is(err, syscall.EADDRINUSE) {
+				s, err = listenSocket(r, packet)
+			}
+		} else {
+			s, err = listenSocket(r, packet)
+		}
+		if err != nil {
+			return err
+		}
+		i.sockets = append(i.sockets, s)
+
+		if n == 0 && len(resolved) > 1 && strings.HasSuffix(r.Address, ":0") {
+			if _, port, err = net.SplitHostPort(s.addr().String()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func listenSocket(a Address, packet bool) (*socket, error) {
+	var err error
+	s := &socket{}
+	if packet {
+		s.packet, err = net.ListenPacket(a.Network, a.Address)
+	} else {
+		s.listener, err = net.Listen(a.Network, a.Address)
+	}
+	return s, err
+}
+
+// ListenListeners is like ListenAll but uses listeners which are already
+// open, such as ones created with special socket options. The Inetd
+// takes ownership of the listeners, closing them on error or Close.
+func ListenListeners(config Config, listeners []net.Listener, program string, arg ...string) (*Inetd, error) {
+	var sockets []*socket
+	for _, l := range listeners {
+		sockets = append(sockets, &socket{listener: l})
+	}
+	if err := config.checkStream(); err != nil {
+		closeSockets(sockets)
+		return nil, err
+	}
+	return fromSockets(config, sockets, program, arg)
+}
+
+// ListenPacketConns is like ListenListeners for datagram sockets.
+func ListenPacketConns(config Config, conns []net.PacketConn, program string, arg ...string) (*Inetd, error) {
+	var sockets []*socket
+	for _, pc := range conns {
+		sockets = append(sockets, &socket{packet: pc})
+	}
+	if err := config.checkPacket(); err != nil {
+		closeSockets(sockets)
+		return nil, err
+	}
+	return fromSockets(config, sockets, program, arg)
+}
+
+// ListenFiles is like ListenListeners for sockets passed as files, such
+// as those from ActivationFiles. Stream and datagram sockets may be
+// mixed. The files are duplicated so the caller may close them.
+func ListenFiles(config Config, files []*os.File, program string, arg ...string) (*Inetd, error) {
+	var sockets []*socket
+	var stream, packet bool
+	for _, f := range files {
+		s := &socket{}
+		var err error
+		if s.listener, err = net.FileListener(f); err == nil {
+			stream = true
+		} else if s.packet, err = net.FilePacketConn(f); err == nil {
+			packet = true
+		} else {
+			closeSockets(sockets)
+			return nil, fmt.Errorf("inetd: %s: %w", f.Name(), err)
+		}
+		sockets = append(sockets, s)
+	}
+
+	var err error
+	if stream {
+		err = config.checkStream()
+	}
+	if packet && err == nil {
+		err = config.checkPacket()
+	}
+	if err != nil {
+		closeSockets(sockets)
+		return nil, err
+	}
+	return fromSockets(config, sockets, program, arg)
+}
+
+func fromSockets(config Config, sockets []*socket, program string, arg []string) (*Inetd, error) {
+	if len(sockets) == 0 {
+		return nil, errors.New("inetd: no sockets to listen on")
+	}
+	i, err := newInetd(config)
+	if err != nil {
+		closeSockets(sockets)
+		return nil, err
+	}
+
+	i.sockets = sockets
+	i.program = program
+	i.args = arg
+	if err := i.loop(); err != nil {
+		return nil, err
+	}
+
+	return i, nil
+}
+
+func closeSockets(sockets []*socket) {
+	for _, s := range sockets {
+		s.close()
+	}
+}
+
+// Files returns duplicates of the sockets in the same order as Addrs,
+// for passing them on to another process. The caller must close them.
+func (i *Inetd) Files() ([]*os.File, error) {
+	var files []*os.File
+	for _, s := range i.sockets {
+		f, err := s.file()
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// Handoff marks the sockets as taken over by another process, such as
+// one given Files, so Close leaves unix socket paths in place.
+func (i *Inetd) Handoff() {
+	for _, s := range i.sockets {
+		if ul, ok := s.listener.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/marineam/experiments/network/neterror"
+)
+
+func TestListenAll(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "sock")
+	config := Config{Instances: 1, Banner: "busy\n"}
+	i, err := ListenAll(config, []Address{
+		{"tcp", "127.0.0.1:0"},
+		{"unix", sock},
+	}, "sh", "-c", "echo ready; cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	addrs := i.Addrs()
+	if len(addrs) != 2 || addrs[1].String() != sock {
+		t.Fatalf("unexpected addresses %v", addrs)
+	}
+
+	c1 := dialReady(t, i)
+	defer c1.Close()
+
+	// The instance limit is shared with the unix socket.
+	c2, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	data, err := ioutil.ReadAll(c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != config.Banner {
+		t.Errorf("got %q, expected %q", data, config.Banner)
+	}
+}
+
+func TestListenAllHost(t *testing.T) {
+	i, err := ListenAll(Config{}, []Address{{"tcp", "localhost:0"}}, "echo", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	var port int
+	for _, addr := range i.Addrs() {
+		tcp := addr.(*net.TCPAddr)
+		if !tcp.IP.IsLoopback() {
+			t.Errorf("%s is not a loopback address", addr)
+		}
+		if port == 0 {
+			port = tcp.Port
+		} else if tcp.Port != port {
+			t.Errorf("%s doesn't use port %d", addr, port)
+		}
+
+		c, err := net.Dial(addr.Network(), addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(c)
+		c.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "hello\n" {
+			t.Errorf("got %q from %s", data, addr)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	lookup := func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+		if host != "dual.test" {
+			return nil, fmt.Errorf("unexpected lookup of %q", host)
+		}
+		return []netip.Addr{netip.MustParseAddr("::1"), netip.MustParseAddr("127.0.0.1")}, nil
+	}
+	for _, tt := range []struct {
+		addr   Address
+		expect []Address
+	}{
+		{Address{"tcp", ":80"}, []Address{{"tcp", ":80"}}},
+		{Address{"tcp", "[::1]:80"}, []Address{{"tcp", "[::1]:80"}}},
+		{Address{"unix", "/run/sock"}, []Address{{"unix", "/run/sock"}}},
+		{Address{"tcp", "dual.test:80"}, []Address{{"tcp", "[::1]:80"}, {"tcp", "127.0.0.1:80"}}},
+		{Address{"tcp4", "dual.test:80"}, []Address{{"tcp4", "127.0.0.1:80"}}},
+		{Address{"udp6", "dual.test:53"}, []Address{{"udp6", "[::1]:53"}}},
+	} {
+		addrs, err := resolve(tt.addr, lookup)
+		if err != nil {
+			t.Errorf("%v: %v", tt.addr, err)
+			continue
+		}
+		if !reflect.DeepEqual(addrs, tt.expect) {
+			t.Errorf("%v: got %v, expected %v", tt.addr, addrs, tt.expect)
+		}
+	}
+}
+
+func TestListenAllPortTaken(t *testing.T) {
+	// The port picked for the first address is always taken on the
+	// second, which is the same one.
+	lookup := func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+		return []netip.Addr{netip.MustParseAddr("127.0.0.1"), netip.MustParseAddr("127.0.0.1")}, nil
+	}
+	i, err := newInetd(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { closeSockets(i.sockets) }()
+	if err := i.listen([]Address{{"tcp", "twice.test:0"}}, false, lookup); err != nil {
+		t.Fatal(err)
+	}
+	if addrs := i.Addrs(); len(addrs) != 2 || addrs[0].String() == addrs[1].String() {
+		t.Errorf("listening on %v, expected two ports", addrs)
+	}
+}
+
+func TestListenConfigNoResolve(t *testing.T) {
+	// localhost may have several addresses but net.Listen only uses one.
+	i, err := ListenConfig(Config{}, "tcp", "localhost:0", "true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+	if addrs := i.Addrs(); len(addrs) != 1 {
+		t.Errorf("listening on %v, expected a single address", addrs)
+	}
+}
+
+func TestListenAllError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// The first socket is closed when the second can't be bound.
+	_, err = ListenAll(Config{}, []Address{
+		{"tcp", "127.0.0.1:0"},
+		{"tcp", l.Addr().String()},
+	}, "true")
+	if err == nil {
+		t.Fatal("ListenAll succeeded on a busy address")
+	}
+}
+
+func TestListenListeners(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := ListenListeners(Config{}, []net.Listener{l}, "echo", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i.Addr() != l.Addr() {
+		t.Errorf("got address %s, expected %s", i.Addr(), l.Addr())
+	}
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(c)
+	c.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello\n" {
+		t.Errorf("got %q, expected %q", data, "hello\n")
+	}
+
+	if err := i.Shutdown(testContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Accept(); !neterror.IsClosed(err) {
+		t.Errorf("listener not closed: %v", err)
+	}
+}
+
+func TestListenFiles(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	f, err := pc.(*net.UDPConn).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := ListenFiles(Config{Mode: Accept}, []*os.File{f}, "cat"); err == nil {
+		t.Error("ListenFiles accepted Accept mode for a datagram socket")
+	}
+
+	i, err := ListenFiles(Config{}, []*os.File{f}, "cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if reply := exchange(t, c, "ping"); reply != "ping" {
+		t.Errorf("got %q, expected %q", reply, "ping")
+	}
+}
+
+func TestFiles(t *testing.T) {
+	for _, a := range []Address{
+		{"tcp", "127.0.0.1:0"},
+		{"unix", filepath.Join(t.TempDir(), "sock")},
+	} {
+		t.Run(a.Network, func(t *testing.T) {
+			i, err := Listen(a.Network, a.Address, "echo", "hello")
+			if err != nil {
+				t.Fatal(err)
+			}
+			files, err := i.Files()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Hand the socket over to a new Inetd like a re-exec would.
+			i2, err := ListenFiles(Config{}, files, "echo", "goodbye")
+			for _, f := range files {
+				f.Close()
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer i2.Close()
+			i.Handoff()
+			if err := i.Drain(testContext(t)); err != nil {
+				t.Fatal(err)
+			}
+
+			c, err := i2.Dial()
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadAll(c)
+			c.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != "goodbye\n" {
+				t.Errorf("got %q, expected %q", data, "goodbye\n")
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build unix
+
+package inetd
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// socketpair returns our end as a net.Conn and the child's as a file.
+func socketpair() (net.Conn, *os.File, error) {
+	syscall.ForkLock.RLock()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err == nil {
+		syscall.CloseOnExec(fds[0])
+		syscall.CloseOnExec(fds[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return nil, nil, os.NewSyscallError("socketpair", err)
+	}
+
+	lfile := os.NewFile(uintptr(fds[0]), "socketpair")
+	defer lfile.Close()
+	local, err := net.FileConn(lfile)
+	if err != nil {
+		syscall.Close(fds[1])
+		return nil, nil, err
+	}
+
+	return local, os.NewFile(uintptr(fds[1]), "socketpair"), nil
+}
+
+func closeOnExec(fd int) {
+	syscall.CloseOnExec(fd)
+}
@@ -0,0 +1,83 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strings"
+	"time"
+)
+
+// How long a client has to complete the TLS handshake.
+const handshakeTimeout = 10 * time.Second
+
+// Names used by mod_ssl for SSL_PROTOCOL.
+var sslProtocols = map[uint16]string{
+	tls.VersionTLS10: "TLSv1",
+	tls.VersionTLS11: "TLSv1.1",
+	tls.VersionTLS12: "TLSv1.2",
+	tls.VersionTLS13: "TLSv1.3",
+}
+
+func sslProtocol(version uint16) string {
+	if name, ok := sslProtocols[version]; ok {
+		return name
+	}
+	return tls.VersionName(version)
+}
+
+// handshake is canceled by Shutdown so it doesn't wait on slow clients.
+func (i *Inetd) handshake(conn net.Conn) (*tls.Conn, error) {
+	ctx, cancel := context.WithTimeout(i.ctx, handshakeTimeout)
+	defer cancel()
+	tconn := tls.Server(conn, i.config.TLSConfig)
+	if err := tconn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	return tconn, nil
+}
+
+// tlsEnv describes a TLS session using the variable names from
+// Apache's mod_ssl, which are also used by ucspi-ssl.
+func tlsEnv(state tls.ConnectionState) []string {
+	env := []string{
+		"SSL_PROTOCOL=" + sslProtocol(state.Version),
+		"SSL_CIPHER=" + tls.CipherSuiteName(state.CipherSuite),
+	}
+	if state.ServerName != "" {
+		env = append(env, "SSL_TLS_SNI="+state.ServerName)
+	}
+
+	if len(state.PeerCertificates) == 0 {
+		return append(env, "SSL_CLIENT_VERIFY=NONE")
+	}
+
+	verify := "GENEROUS" // presented but not verified
+	if len(state.VerifiedChains) != 0 {
+		verify = "SUCCESS"
+	}
+
+	cert := state.PeerCertificates[0]
+	return append(env,
+		"SSL_CLIENT_VERIFY="+verify,
+		"SSL_CLIENT_S_DN="+cert.Subject.String(),
+		"SSL_CLIENT_S_DN_CN="+cert.Subject.CommonName,
+		"SSL_CLIENT_I_DN="+cert.Issuer.String(),
+		"SSL_CLIENT_M_SERIAL="+strings.ToUpper(cert.SerialNumber.Text(16)),
+		"SSL_CLIENT_V_START="+cert.NotBefore.UTC().Format(time.RFC3339),
+		"SSL_CLIENT_V_END="+cert.NotAfter.UTC().Format(time.RFC3339))
+}
@@ -0,0 +1,158 @@
+// Copyright 2019 Michael Marineau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inetd
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testCert returns a self-signed certificate for name.
+func testCert(t *testing.T, name string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth,
+		},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func dialTLS(t *testing.T, i *Inetd, config *tls.Config) *tls.Conn {
+	addr := i.Addr()
+	c, err := tls.Dial(addr.Network(), addr.String(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestTLS(t *testing.T) {
+	config := Config{
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{testCert(t, "localhost")},
+			ClientAuth:   tls.RequireAnyClientCert,
+		},
+	}
+	i, err := ListenConfig(config, "tcp", "localhost:0", "sh", "-c",
+		`echo "$SSL_PROTOCOL $SSL_CLIENT_S_DN $SSL_CLIENT_VERIFY"; cat`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	c := dialTLS(t, i, &tls.Config{
+		Certificates:       []tls.Certificate{testCert(t, "client")},
+		InsecureSkipVerify: true,
+	})
+	defer c.Close()
+
+	if _, err := c.Write([]byte("test")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := "TLSv1.3 CN=client GENEROUS\ntest"; string(data) != expect {
+		t.Errorf("got %q, expected %q", data, expect)
+	}
+}
+
+func TestTLSHandler(t *testing.T) {
+	config := Config{
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{testCert(t, "localhost")},
+		},
+	}
+	handler := HandlerFunc(func(ctx context.Context, conn net.Conn) {
+		if _, ok := conn.(*tls.Conn); ok {
+			conn.Write([]byte("tls"))
+		}
+	})
+	i, err := ListenHandler(config, "tcp", "localhost:0", handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	c := dialTLS(t, i, &tls.Config{InsecureSkipVerify: true})
+	defer c.Close()
+
+	data, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "tls" {
+		t.Errorf("got %q, expected %q", data, "tls")
+	}
+}
+
+func TestTLSShutdown(t *testing.T) {
+	observer, events := eventRecorder()
+	config := Config{
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{testCert(t, "localhost")},
+		},
+		Observer: observer,
+	}
+	i, err := ListenConfig(config, "tcp", "localhost:0", "true")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Never start the handshake.
+	c, err := i.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if e, ok := nextEvent(t, events).(AcceptEvent); !ok {
+		t.Fatalf("expected AcceptEvent, got %T", e)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout/2)
+	defer cancel()
+	if err := i.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown waited on the handshake: %v", err)
+	}
+}